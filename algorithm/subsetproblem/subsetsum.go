@@ -23,63 +23,54 @@ func printArr(arr [][]bool) {
 	fmt.Printf(str.String())
 }
 
-// 返回数组下标
+// 返回数组下标, 从小到大, 凑不出返回nil.
+// 按顺序逐个加入元素, 第一次能凑出sum就停止, 所以用到的最大下标尽量小(越靠前越优先). <=0的元素不会被选
 func GetSubset(set []int, sum int) []int {
-	length := len(set)
-	subSet := make([][]bool, length+1, length+1)
-	for i := range subSet {
-		subSet[i] = make([]bool, sum+1, sum+1)
+	if sum <= 0 {
+		return nil
 	}
-
+	length := len(set)
+	// subSet[i][j]: 前i个元素能否凑出j, 只算到第一次凑出sum的那一行
+	subSet := make([][]bool, 1, length+1)
+	subSet[0] = make([]bool, sum+1)
 	// If sum is 0, then answer is true
-	for i := 0; i <= length; i++ {
-		subSet[i][0] = true
-	}
-
-	// If sum is not 0 and set is empty, then answer is false
-	for i := 1; i <= sum; i++ {
-		subSet[0][i] = false
-	}
+	subSet[0][0] = true
 
 	// Fill the subset table in bottom up manner
 	for i := 1; i <= length; i++ {
+		row := make([]bool, sum+1)
+		row[0] = true
 		for j := 1; j <= sum; j++ {
-			if set[i-1] == sum {
-				// 自己就满足
-				return []int{i - 1}
-			}
-			if j < set[i-1] {
-				subSet[i][j] = subSet[i-1][j]
-			}
-			if j >= set[i-1] {
-				subSet[i][j] = subSet[i-1][j] || subSet[i-1][j-set[i-1]]
+			row[j] = subSet[i-1][j]
+			if set[i-1] > 0 && j >= set[i-1] {
+				row[j] = row[j] || subSet[i-1][j-set[i-1]]
 			}
 		}
+		subSet = append(subSet, row)
+		if row[sum] {
+			return backtrackSubset(set, subSet, sum)
+		}
 	}
 
 	//printArr(subSet)
+	return nil
+}
 
-	if !subSet[length][sum] {
-		//fmt.Printf("Do not have match list.\n")
-		return nil
-	}
-
+// 从最后一行回溯: 最早能凑出target的前缀的最后一个元素一定被选中
+func backtrackSubset(set []int, subSet [][]bool, sum int) []int {
 	var result []int
-	tmpTargetNum := sum
-	for j := sum; j > 0; j-- {
-		if j != tmpTargetNum {
-			continue
-		}
-		for i := length; i >= 0; i-- {
-			if !subSet[i][j] && i+1 <= length && subSet[i+1][j] {
-				// 当前数字是目标之一
-				result = append(result, i) // sum[i] means sum[(i+1 -1)]
-				// 更新target
-				tmpTargetNum -= set[i]
+	for target := sum; target > 0; {
+		for i := 1; i < len(subSet); i++ {
+			if subSet[i][target] {
+				result = append(result, i-1)
+				target -= set[i-1]
 				break
 			}
 		}
 	}
-
+	// 回溯出来是从大到小
+	for l, r := 0, len(result)-1; l < r; l, r = l+1, r-1 {
+		result[l], result[r] = result[r], result[l]
+	}
 	return result
 }
@@ -0,0 +1,29 @@
+package subsetproblem
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetSubset(t *testing.T) {
+	tests := []struct {
+		name string
+		set  []int
+		sum  int
+		want []int
+	}{
+		{"single", []int{4, 5}, 5, []int{1}},
+		{"combination before single", []int{3, 2, 5}, 5, []int{0, 1}},
+		{"shortest prefix", []int{1, 1, 2}, 2, []int{0, 1}},
+		{"skip too big", []int{3, 2, 3, 1}, 4, []int{0, 3}},
+		{"skip non positive", []int{0, -1, 2, 2}, 4, []int{2, 3}},
+		{"not reachable", []int{2, 2, 2}, 5, nil},
+		{"zero sum", []int{1, 2}, 0, nil},
+	}
+	for _, tt := range tests {
+		got := GetSubset(tt.set, tt.sum)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: GetSubset(%v, %d)=%v, want %v", tt.name, tt.set, tt.sum, got, tt.want)
+		}
+	}
+}
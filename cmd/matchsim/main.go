@@ -0,0 +1,191 @@
+package main
+
+/*
+	matchsim: 匹配模拟器
+	按配置生成玩家人口(分数分布/组队人数/到达速率), 用虚拟时钟跑注册的匹配策略,
+	输出每个策略的匹配质量报告(等待时间中位数/p95, 每局分差, 两边平衡, 没匹配上的人数), 格式为json和csv.
+
+	go run ./cmd/matchsim -strategy all -players 2000 -rate 20 -json report.json -csv report.csv
+	匹配日志输出到stderr和logs目录
+*/
+
+import (
+	"flag"
+	"fmt"
+	"github.com/qixi7/xengine_pub/quematch"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 内置策略名
+var strategyNames = map[string]uint32{
+	"normal": quematch.MatchStrategyNormal,
+	"score":  quematch.MatchStrategyScore,
+	"role":   quematch.MatchStrategyRole,
+}
+
+// 一个要跑的策略
+type simStrategy struct {
+	name     string
+	strategy uint32
+}
+
+// 解析策略列表, 支持策略名、all和注册的策略ID
+func parseStrategies(str string) ([]simStrategy, error) {
+	if str == "all" {
+		str = "normal,score,role"
+	}
+	strategies := make([]simStrategy, 0)
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if strategy, ok := strategyNames[name]; ok {
+			strategies = append(strategies, simStrategy{name: name, strategy: strategy})
+			continue
+		}
+		strategy, err := strconv.ParseUint(name, 10, 32)
+		if err != nil || strategy <= quematch.MatchStrategyNone {
+			return nil, fmt.Errorf("unknown strategy %q", name)
+		}
+		strategies = append(strategies, simStrategy{name: name, strategy: uint32(strategy)})
+	}
+	return strategies, nil
+}
+
+// 模拟配置
+type simCfg struct {
+	pop        populationCfg
+	seed       int64
+	need       int32
+	single     int32
+	tick       time.Duration
+	tickGap    int64
+	perJob     int32
+	maxWait    int64
+	drain      time.Duration
+	expandBase int32
+	expandStep int32
+	expandMax  int32
+}
+
+// 跑一个策略, 返回报告
+func runStrategy(cfg *simCfg, one simStrategy) (*strategyReport, error) {
+	queKey := quematch.MatchQueueKey{MapID: 1, MatchStrategy: one.strategy}
+	// 每个策略用同一个种子重新生成人口, elem进队后会被修改不能共用
+	arrivals := genArrivals(&cfg.pop, queKey, cfg.seed)
+	collector := &reportCollector{}
+	coll := quematch.NewSimDataCollector(collector, time.Unix(0, 0), cfg.tick)
+	if coll == nil {
+		return nil, fmt.Errorf("init match collector failed")
+	}
+	defer coll.EndSimulation()
+	collector.coll = coll
+
+	matchMgr := coll.GetMatchMgr()
+	matchMgr.SetMatchBaseCfg(quematch.MatchBaseCfg{
+		MatchTickGap:   cfg.tickGap,
+		MaxWaitSecond:  cfg.maxWait,
+		MaxMatchPerJob: cfg.perJob,
+	})
+	if one.strategy == quematch.MatchStrategyScore && cfg.expandBase > 0 {
+		coll.RegisterMatchAchieve(one.strategy, &quematch.ScoreMatchAchieve{
+			Expand: &quematch.ScoreExpandCfg{
+				Curve:     quematch.ExpandCurveLinear,
+				BaseRange: cfg.expandBase,
+				StepRange: cfg.expandStep,
+				MaxRange:  cfg.expandMax,
+			},
+		})
+	}
+	mapInfo := quematch.MapInfo{
+		MapID:          queKey.MapID,
+		MatchTotalNeed: cfg.need,
+		MatchSingleMax: cfg.single,
+	}
+	if one.strategy == quematch.MatchStrategyRole {
+		mapInfo.RoleSlots = genRoleSlots(cfg.pop.roleNum, cfg.single)
+	}
+	coll.InitClientMapInfo(quematch.ClientKey{ServerID: 1}, mapInfo)
+
+	duration := cfg.drain
+	if len(arrivals) > 0 {
+		duration += arrivals[len(arrivals)-1].Offset
+	}
+	if !coll.ReplayTrace(arrivals, duration) {
+		return nil, fmt.Errorf("replay trace failed")
+	}
+	return collector.build(one.name, arrivals), nil
+}
+
+func main() {
+	cfg := &simCfg{}
+	strategyStr := flag.String("strategy", "all", "strategies to run: all, or comma list of normal/score/role/registered id")
+	partyStr := flag.String("party", "1:0.7,2:0.2,3:0.1", "party size weights, size:weight list")
+	jsonPath := flag.String("json", "-", "json report path, - for stdout, empty to skip")
+	csvPath := flag.String("csv", "", "csv report path, - for stdout, empty to skip")
+	flag.IntVar(&cfg.pop.playerNum, "players", 1000, "total players")
+	flag.Float64Var(&cfg.pop.arrivalRate, "rate", 10, "player arrivals per second")
+	flag.Float64Var(&cfg.pop.ratingMean, "rating-mean", 1500, "rating mean")
+	flag.Float64Var(&cfg.pop.ratingStd, "rating-std", 300, "rating standard deviation")
+	flag.IntVar(&cfg.pop.roleNum, "roles", 3, "role count, each player can play 1~2 roles")
+	flag.Int64Var(&cfg.seed, "seed", 1, "random seed")
+	var need, single, perJob int
+	flag.IntVar(&need, "need", 10, "players per match")
+	flag.IntVar(&single, "single", 5, "players per side")
+	flag.IntVar(&perJob, "per-job", 8, "max matches per job")
+	flag.DurationVar(&cfg.tick, "tick", 100*time.Millisecond, "simulated time per frame")
+	flag.Int64Var(&cfg.tickGap, "tick-gap", 10, "frames between match rounds")
+	flag.Int64Var(&cfg.maxWait, "max-wait", 0, "max wait seconds before leaving queue, <=0 unlimited")
+	flag.DurationVar(&cfg.drain, "drain", time.Minute, "simulated time to keep matching after the last arrival")
+	var expandBase, expandStep, expandMax int
+	flag.IntVar(&expandBase, "expand-base", 100, "score strategy initial rating range, <=0 unlimited")
+	flag.IntVar(&expandStep, "expand-step", 50, "score strategy rating range added per second")
+	flag.IntVar(&expandMax, "expand-max", 0, "score strategy max rating range, <=0 unlimited")
+	flag.Parse()
+	cfg.need, cfg.single, cfg.perJob = int32(need), int32(single), int32(perJob)
+	cfg.expandBase, cfg.expandStep, cfg.expandMax = int32(expandBase), int32(expandStep), int32(expandMax)
+
+	if cfg.pop.playerNum <= 0 || cfg.pop.arrivalRate <= 0 || cfg.need <= 0 || cfg.single <= 0 || cfg.tick <= 0 {
+		fmt.Fprintln(os.Stderr, "players, rate, need, single and tick must be positive")
+		os.Exit(2)
+	}
+	var err error
+	if cfg.pop.partyWeight, err = parsePartyWeight(*partyStr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	strategies, err := parseStrategies(*strategyStr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	out := &simOutput{
+		Seed:    cfg.seed,
+		Players: cfg.pop.playerNum,
+		Rate:    cfg.pop.arrivalRate,
+		Reports: make([]*strategyReport, 0, len(strategies)),
+	}
+	for _, one := range strategies {
+		report, err := runStrategy(cfg, one)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "strategy %s: %v\n", one.name, err)
+			os.Exit(1)
+		}
+		out.Reports = append(out.Reports, report)
+	}
+	out.Drain = cfg.drain.String()
+	if *jsonPath != "" {
+		if err = writeJSON(*jsonPath, out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *csvPath != "" {
+		if err = writeCSV(*csvPath, out.Reports); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/qixi7/xengine_pub/quematch"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 人口配置
+type populationCfg struct {
+	playerNum   int             // 总人数
+	arrivalRate float64         // 每秒到达人数
+	ratingMean  float64         // 分数均值
+	ratingStd   float64         // 分数标准差
+	partyWeight map[int]float64 // 组队人数 -> 权重
+	roleNum     int             // 职业数量, 每人随机会1~2个职业
+}
+
+// 解析组队人数分布, 如"1:0.7,2:0.2,3:0.1"
+func parsePartyWeight(str string) (map[int]float64, error) {
+	partyWeight := make(map[int]float64)
+	for _, item := range strings.Split(str, ",") {
+		kv := strings.Split(strings.TrimSpace(item), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad party item %q", item)
+		}
+		size, err := strconv.Atoi(kv[0])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("bad party size %q", kv[0])
+		}
+		weight, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("bad party weight %q", kv[1])
+		}
+		partyWeight[size] = weight
+	}
+	return partyWeight, nil
+}
+
+// 按权重随机组队人数
+func randPartySize(r *rand.Rand, sizes []int, weights []float64, total float64) int {
+	pick := r.Float64() * total
+	for i, size := range sizes {
+		if pick < weights[i] {
+			return size
+		}
+		pick -= weights[i]
+	}
+	return sizes[len(sizes)-1]
+}
+
+// 生成到达序列. 到达间隔服从指数分布, 分数服从正态分布
+func genArrivals(cfg *populationCfg, queKey quematch.MatchQueueKey, seed int64) []quematch.MatchArrival {
+	r := rand.New(rand.NewSource(seed))
+	sizes := make([]int, 0, len(cfg.partyWeight))
+	for size := range cfg.partyWeight {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	weights := make([]float64, 0, len(sizes))
+	var totalWeight float64
+	for _, size := range sizes {
+		weights = append(weights, cfg.partyWeight[size])
+		totalWeight += cfg.partyWeight[size]
+	}
+
+	arrivals := make([]quematch.MatchArrival, 0)
+	var offset float64
+	gamerID := uint64(0)
+	for teamID := uint64(1); int(gamerID) < cfg.playerNum; teamID++ {
+		size := randPartySize(r, sizes, weights, totalWeight)
+		if left := cfg.playerNum - int(gamerID); size > left {
+			size = left
+		}
+		data := quematch.NewScoreMatchElemData()
+		for i := 0; i < size; i++ {
+			gamerID++
+			score := int32(math.Round(r.NormFloat64()*cfg.ratingStd + cfg.ratingMean))
+			data.Gamers = append(data.Gamers, quematch.ScoreMatchGamer{
+				GamerID: gamerID,
+				Score:   score,
+				Roles:   randRoles(r, cfg.roleNum),
+			})
+		}
+		elemKey := quematch.MatchElemKey{ElemType: quematch.MatchElemTeam, ElemID: teamID}
+		if size == 1 {
+			elemKey = quematch.MatchElemKey{ElemType: quematch.MatchElemPerson, ElemID: gamerID}
+		}
+		// 每秒arrivalRate人, 按单元折算到达间隔
+		offset += r.ExpFloat64() * float64(size) / cfg.arrivalRate
+		arrivals = append(arrivals, quematch.MatchArrival{
+			Offset: time.Duration(offset * float64(time.Second)),
+			QueKey: queKey,
+			Elem:   quematch.NewMatchElem(elemKey, data, &simElemFunc{}),
+		})
+	}
+	return arrivals
+}
+
+// 随机1~2个职业
+func randRoles(r *rand.Rand, roleNum int) []quematch.MatchRole {
+	if roleNum <= 0 {
+		return nil
+	}
+	roles := []quematch.MatchRole{quematch.MatchRole(r.Intn(roleNum) + 1)}
+	if roleNum > 1 && r.Intn(2) == 0 {
+		second := quematch.MatchRole(r.Intn(roleNum) + 1)
+		if second != roles[0] {
+			roles = append(roles, second)
+		}
+	}
+	return roles
+}
+
+// 单组职业模板: singleMax个位置轮流分给各职业
+func genRoleSlots(roleNum int, singleMax int32) []quematch.MatchRoleSlot {
+	if roleNum <= 0 {
+		return nil
+	}
+	slots := make([]quematch.MatchRoleSlot, roleNum)
+	for i := range slots {
+		slots[i].Role = quematch.MatchRole(i + 1)
+	}
+	for i := int32(0); i < singleMax; i++ {
+		slots[int(i)%roleNum].Num++
+	}
+	valid := slots[:0]
+	for _, oneSlot := range slots {
+		if oneSlot.Num > 0 {
+			valid = append(valid, oneSlot)
+		}
+	}
+	return valid
+}
+
+// 模拟用elem回调, 不处理
+type simElemFunc struct {
+}
+
+func (sef *simElemFunc) OnEnterQueue(quematch.MatchQueueKey, *quematch.MatchElem) {
+}
+
+func (sef *simElemFunc) OnLeaveQueue(quematch.MatchQueueKey, *quematch.MatchElem, quematch.MatchLeaveReason) {
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"github.com/json-iterator/go"
+	"github.com/qixi7/xengine_pub/quematch"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// 一个策略的匹配质量报告
+type strategyReport struct {
+	Strategy         string  `json:"strategy"`
+	Players          int     `json:"players"`           // 总人数
+	Elems            int     `json:"elems"`             // 总单元数
+	Matches          int     `json:"matches"`           // 成局数
+	MatchedPlayers   int     `json:"matched_players"`   // 匹配成功人数
+	UnmatchedPlayers int     `json:"unmatched_players"` // 模拟结束时还没匹配上的人数
+	UnmatchedElems   int     `json:"unmatched_elems"`   // 模拟结束时还没匹配上的单元数
+	WaitMedianSec    float64 `json:"wait_median_sec"`   // 匹配成功玩家等待秒数中位数
+	WaitP95Sec       float64 `json:"wait_p95_sec"`      // 匹配成功玩家等待秒数p95
+	SpreadMean       float64 `json:"spread_mean"`       // 每局最高分-最低分, 平均值
+	SpreadP95        float64 `json:"spread_p95"`        // 每局最高分-最低分, p95
+	ImbalanceMean    float64 `json:"imbalance_mean"`    // 每局各边平均分最大差, 平均值
+	ImbalanceP95     float64 `json:"imbalance_p95"`     // 每局各边平均分最大差, p95
+}
+
+// 收集匹配结果, 实现quematch.IMatchDataCollOK
+type reportCollector struct {
+	coll       *quematch.MatchDataCollector
+	matches    int
+	matched    int
+	matchElems int
+	waits      []float64
+	spreads    []float64
+	imbalances []float64
+}
+
+func (rc *reportCollector) CollMatchOK(result *quematch.MatchResult) {
+	now := rc.coll.Now()
+	rc.matches++
+	minScore, maxScore := int32(math.MaxInt32), int32(math.MinInt32)
+	result.ForeachMatchElem(func(oneElem *quematch.MatchElem, _ int) {
+		rc.matchElems++
+		wait := now.Sub(oneElem.StartTime).Seconds()
+		data, ok := oneElem.ElemData.(*quematch.ScoreMatchElemData)
+		if !ok {
+			return
+		}
+		for _, oneGamer := range data.Gamers {
+			rc.matched++
+			rc.waits = append(rc.waits, wait)
+			if oneGamer.Score < minScore {
+				minScore = oneGamer.Score
+			}
+			if oneGamer.Score > maxScore {
+				maxScore = oneGamer.Score
+			}
+		}
+	})
+	if minScore <= maxScore {
+		rc.spreads = append(rc.spreads, float64(maxScore-minScore))
+	}
+	// 有分边时统计各边平均分最大差
+	if len(result.Sides) >= 2 {
+		minAvg, maxAvg := math.MaxFloat64, -math.MaxFloat64
+		for _, oneSide := range result.Sides {
+			if oneSide.GamerNum <= 0 {
+				continue
+			}
+			avg := float64(oneSide.TotalScore) / float64(oneSide.GamerNum)
+			minAvg = math.Min(minAvg, avg)
+			maxAvg = math.Max(maxAvg, avg)
+		}
+		if minAvg <= maxAvg {
+			rc.imbalances = append(rc.imbalances, maxAvg-minAvg)
+		}
+	}
+}
+
+func (rc *reportCollector) CollSupplyOK(*quematch.MatchResult, *quematch.SupplyInfo) {
+}
+
+// 生成报告
+func (rc *reportCollector) build(name string, arrivals []quematch.MatchArrival) *strategyReport {
+	players := 0
+	for _, oneArrival := range arrivals {
+		players += oneArrival.Elem.ElemData.GamerNum()
+	}
+	report := &strategyReport{
+		Strategy:         name,
+		Players:          players,
+		Elems:            len(arrivals),
+		Matches:          rc.matches,
+		MatchedPlayers:   rc.matched,
+		UnmatchedPlayers: players - rc.matched,
+		UnmatchedElems:   len(arrivals) - rc.matchElems,
+	}
+	report.WaitMedianSec = percentile(rc.waits, 0.5)
+	report.WaitP95Sec = percentile(rc.waits, 0.95)
+	report.SpreadMean = mean(rc.spreads)
+	report.SpreadP95 = percentile(rc.spreads, 0.95)
+	report.ImbalanceMean = mean(rc.imbalances)
+	report.ImbalanceP95 = percentile(rc.imbalances, 0.95)
+	return report
+}
+
+// 最近秩法求分位数, 没有数据返回0
+func percentile(values []float64, p float64) float64 {
+	if len(values) <= 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+func mean(values []float64) float64 {
+	if len(values) <= 0 {
+		return 0
+	}
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// 完整输出
+type simOutput struct {
+	Seed    int64             `json:"seed"`
+	Players int               `json:"players"`
+	Rate    float64           `json:"rate"`
+	Drain   string            `json:"drain"`
+	Reports []*strategyReport `json:"reports"`
+}
+
+// 写json报告, path为"-"时输出到标准输出
+func writeJSON(path string, out *simOutput) error {
+	buf, err := jsoniter.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err = os.Stdout.Write(append(buf, '\n'))
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+// 写csv报告, 每个策略一行
+func writeCSV(path string, reports []*strategyReport) error {
+	f := os.Stdout
+	if path != "-" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"strategy", "players", "elems", "matches", "matched_players", "unmatched_players",
+		"unmatched_elems", "wait_median_sec", "wait_p95_sec", "spread_mean", "spread_p95",
+		"imbalance_mean", "imbalance_p95"})
+	for _, r := range reports {
+		w.Write([]string{r.Strategy, strconv.Itoa(r.Players), strconv.Itoa(r.Elems), strconv.Itoa(r.Matches),
+			strconv.Itoa(r.MatchedPlayers), strconv.Itoa(r.UnmatchedPlayers), strconv.Itoa(r.UnmatchedElems),
+			formatFloat(r.WaitMedianSec), formatFloat(r.WaitP95Sec), formatFloat(r.SpreadMean),
+			formatFloat(r.SpreadP95), formatFloat(r.ImbalanceMean), formatFloat(r.ImbalanceP95)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
@@ -0,0 +1,184 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_pub/algorithm/subsetproblem"
+)
+
+/*
+	matchbalance.go: 分边平衡
+	把一次匹配的所有单元分成人数相同的两边, 组队单元不拆开, 两边总分尽量接近
+*/
+
+// 一边
+type MatchSide struct {
+	Elems      []*MatchElem
+	GamerNum   int32 // 人数
+	TotalScore int64 // 总分
+}
+
+func newMatchSide() *MatchSide {
+	return &MatchSide{
+		Elems: make([]*MatchElem, 0),
+	}
+}
+
+func (ms *MatchSide) addElem(elem *MatchElem) {
+	ms.Elems = append(ms.Elems, elem)
+	ms.GamerNum += int32(elem.ElemData.GamerNum())
+	if data, ok := elem.ElemData.(*ScoreMatchElemData); ok {
+		ms.TotalScore += data.TotalScore()
+	}
+}
+
+// 平均分
+func (ms *MatchSide) AvgScore() int32 {
+	if ms.GamerNum <= 0 {
+		return 0
+	}
+	return int32(ms.TotalScore / int64(ms.GamerNum))
+}
+
+// 背包状态: 已选人数和已选总分
+type balanceState struct {
+	gamerNum int32
+	score    int64
+}
+
+func (bs balanceState) less(other balanceState) bool {
+	if bs.gamerNum != other.gamerNum {
+		return bs.gamerNum < other.gamerNum
+	}
+	return bs.score < other.score
+}
+
+// 背包回溯信息
+type balanceFrom struct {
+	prev balanceState
+	took bool
+}
+
+// 把elems分成两边, 每边sideGamerNum人. 无法拆分时返回nil
+func BalanceTwoSides(elems []*MatchElem, sideGamerNum int32) []*MatchSide {
+	if sideGamerNum <= 0 {
+		return nil
+	}
+	gamerNums := make([]int32, len(elems))
+	scores := make([]int64, len(elems))
+	var totalGamer int32
+	var totalScore int64
+	hasScore := false
+	for i := 0; i < len(elems); i++ {
+		gamerNums[i] = int32(elems[i].ElemData.GamerNum())
+		if data, ok := elems[i].ElemData.(*ScoreMatchElemData); ok {
+			scores[i] = data.TotalScore()
+		}
+		if scores[i] != 0 {
+			hasScore = true
+		}
+		totalGamer += gamerNums[i]
+		totalScore += scores[i]
+	}
+	if totalGamer != sideGamerNum*2 {
+		return nil
+	}
+
+	var picked []bool
+	if hasScore {
+		picked = balanceByScore(gamerNums, scores, sideGamerNum, totalScore)
+	} else {
+		picked = balanceByNum(gamerNums, sideGamerNum)
+	}
+	if picked == nil {
+		return nil
+	}
+	sides := []*MatchSide{newMatchSide(), newMatchSide()}
+	for i := 0; i < len(elems); i++ {
+		if picked[i] {
+			sides[0].addElem(elems[i])
+		} else {
+			sides[1].addElem(elems[i])
+		}
+	}
+	return sides
+}
+
+// 没有分数时只需要按人数拆分, 直接用子集和
+func balanceByNum(gamerNums []int32, sideGamerNum int32) []bool {
+	set := make([]int, len(gamerNums))
+	for i := 0; i < len(gamerNums); i++ {
+		set[i] = int(gamerNums[i])
+	}
+	subset := subsetproblem.GetSubset(set, int(sideGamerNum))
+	if len(subset) <= 0 {
+		return nil
+	}
+	picked := make([]bool, len(gamerNums))
+	for _, idx := range subset {
+		picked[idx] = true
+	}
+	return picked
+}
+
+// 有分数时在人数恰好为sideGamerNum的子集中找总分最接近一半的
+func balanceByScore(gamerNums []int32, scores []int64, sideGamerNum int32, totalScore int64) []bool {
+	// layers[i]: 考虑前i个单元后所有可达状态
+	layers := make([]map[balanceState]balanceFrom, len(gamerNums)+1)
+	layers[0] = map[balanceState]balanceFrom{{}: {}}
+	for i := 0; i < len(gamerNums); i++ {
+		layers[i+1] = make(map[balanceState]balanceFrom, len(layers[i])*2)
+		for state := range layers[i] {
+			layers[i+1][state] = balanceFrom{prev: state}
+			next := balanceState{
+				gamerNum: state.gamerNum + gamerNums[i],
+				score:    state.score + scores[i],
+			}
+			if next.gamerNum > sideGamerNum {
+				continue
+			}
+			// 同一状态有多条来路时取固定一条, 保证结果稳定
+			if from, ok := layers[i+1][next]; !ok || (from.took && state.less(from.prev)) {
+				layers[i+1][next] = balanceFrom{prev: state, took: true}
+			}
+		}
+	}
+
+	// 找最接近一半的状态
+	var best balanceState
+	var bestDiff int64 = -1
+	for state := range layers[len(gamerNums)] {
+		if state.gamerNum != sideGamerNum {
+			continue
+		}
+		diff := totalScore - state.score*2
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff < 0 || diff < bestDiff || (diff == bestDiff && state.score < best.score) {
+			best = state
+			bestDiff = diff
+		}
+	}
+	if bestDiff < 0 {
+		return nil
+	}
+
+	// 回溯选中的单元
+	picked := make([]bool, len(gamerNums))
+	state := best
+	for i := len(gamerNums); i > 0; i-- {
+		from := layers[i][state]
+		picked[i-1] = from.took
+		state = from.prev
+	}
+	return picked
+}
+
+// 把匹配结果分成两边, 结果写入result.Sides
+func BalanceMatchResult(result *MatchResult, sideGamerNum int32) bool {
+	sides := BalanceTwoSides(result.Groups, sideGamerNum)
+	if sides == nil {
+		return false
+	}
+	result.Sides = sides
+	return true
+}
@@ -0,0 +1,91 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 每边的elemID集合, 按第一个elem排序后比较
+func sideElemIDs(side *MatchSide) map[uint64]struct{} {
+	set := make(map[uint64]struct{}, len(side.Elems))
+	for _, oneElem := range side.Elems {
+		set[oneElem.ElemKey.ElemID] = struct{}{}
+	}
+	return set
+}
+
+func checkSides(t *testing.T, sides []*MatchSide, want ...[]uint64) {
+	t.Helper()
+	if len(sides) != len(want) {
+		t.Fatalf("got %d sides, want %d", len(sides), len(want))
+	}
+	for _, oneWant := range want {
+		found := false
+		for _, oneSide := range sides {
+			set := sideElemIDs(oneSide)
+			if len(set) != len(oneWant) {
+				continue
+			}
+			match := true
+			for _, id := range oneWant {
+				if _, ok := set[id]; !ok {
+					match = false
+				}
+			}
+			found = found || match
+		}
+		if !found {
+			t.Fatalf("no side with elems %v", oneWant)
+		}
+	}
+}
+
+func TestBalanceTwoSidesMinimizesDiff(t *testing.T) {
+	elems := []*MatchElem{
+		newTestElem(1, 1000),
+		newTestElem(2, 1100),
+		newTestElem(3, 1200),
+		newTestElem(4, 1300),
+	}
+	sides := BalanceTwoSides(elems, 2)
+	checkSides(t, sides, []uint64{1, 4}, []uint64{2, 3})
+	if sides[0].TotalScore != sides[1].TotalScore {
+		t.Fatalf("side score %d vs %d", sides[0].TotalScore, sides[1].TotalScore)
+	}
+}
+
+func TestBalanceTwoSidesKeepsTeams(t *testing.T) {
+	elems := []*MatchElem{
+		newTestElem(1, 1000, 1020),
+		newTestElem(2, 1500),
+		newTestElem(3, 1400),
+		newTestElem(4, 1100, 1150),
+		newTestElem(5, 1200),
+		newTestElem(6, 1300),
+	}
+	sides := BalanceTwoSides(elems, 4)
+	for _, oneSide := range sides {
+		if oneSide.GamerNum != 4 {
+			t.Fatalf("side gamerNum=%d, want 4", oneSide.GamerNum)
+		}
+	}
+	// 总分9670, 最接近一半的是2020+1500+1300=4820 vs 2250+1400+1200=4850
+	checkSides(t, sides, []uint64{1, 2, 6}, []uint64{3, 4, 5})
+	if BalanceTwoSides(elems[:2], 2) != nil {
+		t.Fatal("odd gamer total should not split")
+	}
+}
+
+func TestScoreMatchBalancedSides(t *testing.T) {
+	trace := testTrace(MatchStrategyScore, 0,
+		newTestElem(1, 1000),
+		newTestElem(2, 1100),
+		newTestElem(3, 1200),
+		newTestElem(4, 1300),
+	)
+	results := simMatch(t, MapInfo{MatchTotalNeed: 4, MatchSingleMax: 2}, trace, 3*time.Second, nil)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkSides(t, results[0].Sides, []uint64{1, 4}, []uint64{2, 3})
+}
@@ -0,0 +1,99 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xcontainer/job"
+	"sync"
+	"time"
+)
+
+/*
+	matchclock.go: 可替换的时钟和同步执行job
+	模拟/回放时使用虚拟时钟和同步job, 同样的输入得到同样的匹配结果. 时钟是每个mgr自己的, 模拟不影响其他mgr.
+	job耗时和超时仍按真实时间统计
+*/
+
+// 时钟
+type IMatchClock interface {
+	Now() time.Time
+}
+
+// 真实时钟
+type realClock struct {
+}
+
+func (rc realClock) Now() time.Time {
+	return time.Now()
+}
+
+// 虚拟时钟, 只有手动推进才会走
+type VirtualClock struct {
+	mu  sync.RWMutex
+	now time.Time
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (vc *VirtualClock) Now() time.Time {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	return vc.now
+}
+
+// 往前推进d
+func (vc *VirtualClock) Advance(d time.Duration) {
+	vc.mu.Lock()
+	vc.now = vc.now.Add(d)
+	vc.mu.Unlock()
+}
+
+// 设置到t, 不能往回走
+func (vc *VirtualClock) Set(t time.Time) {
+	vc.mu.Lock()
+	if t.After(vc.now) {
+		vc.now = t
+	}
+	vc.mu.Unlock()
+}
+
+// 设置匹配时钟, nil恢复真实时钟. 只在启动前设置, 进队的elem会记下所在mgr的时钟
+func (mqm *MatchQueueMgr) SetMatchClock(clock IMatchClock) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	mqm.clock = clock
+}
+
+func (mqm *MatchQueueMgr) now() time.Time {
+	return mqm.clock.Now()
+}
+
+func (mqm *MatchQueueMgr) since(t time.Time) time.Duration {
+	return mqm.now().Sub(t)
+}
+
+// 设置是否同步执行job. 同步时job不投递到job线程, 每次匹配后在主线程按投递顺序执行并处理结果
+func (mqm *MatchQueueMgr) SetSyncJob(sync bool) {
+	mqm.syncJob = sync
+}
+
+// 投递job
+func (mqm *MatchQueueMgr) postJob(oneJob job.Do) {
+	if mqm.syncJob {
+		mqm.syncJobs = append(mqm.syncJobs, oneJob)
+		return
+	}
+	mqm.getJobController().PostJob(oneJob)
+}
+
+// 同步执行所有待执行的job
+func (mqm *MatchQueueMgr) runSyncJobs() {
+	for len(mqm.syncJobs) > 0 {
+		jobs := mqm.syncJobs
+		mqm.syncJobs = nil
+		for _, oneJob := range jobs {
+			oneJob.DoJob().DoReturn()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 模拟用的虚拟时钟只影响自己的mgr
+func TestSimClockPerManager(t *testing.T) {
+	coll := NewSimDataCollector(&testCollOK{}, testStart, 100*time.Millisecond)
+	if coll == nil {
+		t.Fatal("NewSimDataCollector failed")
+	}
+	defer coll.EndSimulation()
+	realMgr := NewMatchQueueMgr(nil)
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	realElem := newTestElem(1, 0)
+	realMgr.EnterWaitQueue(queKey, realElem)
+	simElem := newTestElem(2, 0)
+	coll.ReplayTrace(testTrace(MatchStrategyNormal, 0, simElem), 5*time.Second)
+	if wait := simElem.WaitSecond(); wait < 5 {
+		t.Fatalf("sim elem wait=%d, want >=5", wait)
+	}
+	if wait := realElem.WaitSecond(); wait != 0 {
+		t.Fatalf("real elem wait=%d, want 0", wait)
+	}
+	if !realMgr.now().After(testStart.Add(time.Hour)) {
+		t.Fatal("real manager uses the virtual clock")
+	}
+}
+
+// 进队时间按mgr的时钟算
+func TestEnterUsesManagerClock(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	clock := NewVirtualClock(testStart)
+	mqm.SetMatchClock(clock)
+	elem := newTestElem(1, 0)
+	mqm.EnterWaitQueue(MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}, elem)
+	if !elem.StartTime.Equal(testStart) {
+		t.Fatalf("StartTime=%v, want %v", elem.StartTime, testStart)
+	}
+	clock.Advance(7 * time.Second)
+	if wait := elem.WaitSecond(); wait != 7 {
+		t.Fatalf("wait=%d, want 7", wait)
+	}
+	info, ok := mqm.GetQueueWaitInfo(elem.ElemKey)
+	if !ok || info.WaitSecond != 7 {
+		t.Fatalf("wait info=%+v, want WaitSecond 7", info)
+	}
+}
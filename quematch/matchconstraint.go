@@ -0,0 +1,399 @@
+package quematch
+
+import (
+	"time"
+)
+
+/*
+	matchconstraint.go: 匹配约束
+	屏蔽名单、最近N局对手、同公会限制. 主线程修改, 匹配job拿到的是只读快照.
+	每个玩家的约束是不可变的记录, 修改时换一条新记录. 快照引用一份冻结的全量表加一份小的增量表,
+	生成快照只复制增量, 增量多了才合并进全量表, 每局都有修改时也不用每次全部重建.
+	最近对手离开匹配时不删(否则取消再进队就能绕过), 最近一局超过保留时间后整条删掉
+*/
+
+// 无序玩家对
+type gamerPair struct {
+	low  uint64
+	high uint64
+}
+
+func newGamerPair(a, b uint64) gamerPair {
+	if a > b {
+		a, b = b, a
+	}
+	return gamerPair{low: a, high: b}
+}
+
+// 一个玩家的约束, 生成后不再修改
+type gamerConstraint struct {
+	recent     []uint64  // 最近对手(按局展开)
+	recentLen  []int     // 每局对手人数
+	guildID    uint64    // 公会ID, 0表示没有
+	recentTime time.Time // 最近一局的时间
+}
+
+func (gc *gamerConstraint) empty() bool {
+	return len(gc.recent) <= 0 && gc.guildID == 0
+}
+
+func (gc *gamerConstraint) isRecent(gamerID uint64) bool {
+	for _, oneOpponent := range gc.recent {
+		if oneOpponent == gamerID {
+			return true
+		}
+	}
+	return false
+}
+
+// 约束只读快照, 匹配线程使用
+type MatchConstraintView struct {
+	blocks map[gamerPair]struct{}      // 屏蔽名单, 和主线程共用, 主线程修改前会先复制
+	base   map[uint64]*gamerConstraint // 冻结的全量表
+	delta  map[uint64]*gamerConstraint // 生成快照时的增量, nil表示已删除
+}
+
+func (cv *MatchConstraintView) gamer(gamerID uint64) *gamerConstraint {
+	if gc, ok := cv.delta[gamerID]; ok {
+		return gc
+	}
+	return cv.base[gamerID]
+}
+
+// 两个玩家能否匹配到一起
+func (cv *MatchConstraintView) CanPair(a, b uint64, forbidSameGuild bool) bool {
+	if cv == nil || a == b {
+		return true
+	}
+	if _, ok := cv.blocks[newGamerPair(a, b)]; ok {
+		return false
+	}
+	gcA, gcB := cv.gamer(a), cv.gamer(b)
+	if gcA == nil && gcB == nil {
+		return true
+	}
+	// 最近对手两边任意一边记着都不行
+	if (gcA != nil && gcA.isRecent(b)) || (gcB != nil && gcB.isRecent(a)) {
+		return false
+	}
+	if forbidSameGuild && gcA != nil && gcB != nil && gcA.guildID != 0 && gcA.guildID == gcB.guildID {
+		return false
+	}
+	return true
+}
+
+// 增量超过全量表的1/deltaMergeDiv(至少deltaMergeMin)时合并
+const (
+	deltaMergeDiv = 8
+	deltaMergeMin = 64
+)
+
+// 最近对手默认保留时间和过期检查间隔
+const (
+	defaultRecentKeep = 30 * time.Minute
+	recentExpireGap   = time.Minute
+)
+
+// 匹配约束注册表, 主线程使用
+type matchConstraints struct {
+	blocks       map[gamerPair]struct{}
+	blocksShared bool                        // blocks已被快照引用, 修改前要复制
+	recentNum    int                         // 记录最近多少局对手, <=0不记录
+	recentKeep   time.Duration               // 最近对手保留多久, <=0不过期
+	lastExpire   time.Time                   // 上次检查过期的时间
+	base         map[uint64]*gamerConstraint // 冻结的全量表, 可能被快照引用, 只能整体替换
+	delta        map[uint64]*gamerConstraint // 之后的修改, nil表示删除
+	guildForbid  map[MatchQueueKey]struct{}  // 禁止同公会的队列
+	view         *MatchConstraintView        // 当前快照
+}
+
+func newMatchConstraints() *matchConstraints {
+	return &matchConstraints{
+		blocks:      make(map[gamerPair]struct{}),
+		recentKeep:  defaultRecentKeep,
+		base:        make(map[uint64]*gamerConstraint),
+		delta:       make(map[uint64]*gamerConstraint),
+		guildForbid: make(map[MatchQueueKey]struct{}),
+	}
+}
+
+// 修改后丢弃快照
+func (mc *matchConstraints) dirty() {
+	mc.view = nil
+}
+
+// 当前的玩家约束, 没有返回nil
+func (mc *matchConstraints) gamer(gamerID uint64) *gamerConstraint {
+	if gc, ok := mc.delta[gamerID]; ok {
+		return gc
+	}
+	return mc.base[gamerID]
+}
+
+// 换成新的玩家约束, 空的当作删除
+func (mc *matchConstraints) setGamer(gamerID uint64, gc *gamerConstraint) {
+	if gc == nil || gc.empty() {
+		if _, ok := mc.base[gamerID]; ok {
+			mc.delta[gamerID] = nil
+		} else {
+			delete(mc.delta, gamerID)
+		}
+	} else {
+		mc.delta[gamerID] = gc
+	}
+	mc.dirty()
+}
+
+// 复制一份玩家约束用来修改
+func (mc *matchConstraints) copyGamer(gamerID uint64) *gamerConstraint {
+	gc := &gamerConstraint{}
+	if old := mc.gamer(gamerID); old != nil {
+		*gc = *old
+	}
+	return gc
+}
+
+// 增量并入全量表. 旧的全量表可能还被快照引用, 生成新的
+func (mc *matchConstraints) mergeDelta() {
+	base := make(map[uint64]*gamerConstraint, len(mc.base)+len(mc.delta))
+	for gamerID, gc := range mc.base {
+		base[gamerID] = gc
+	}
+	for gamerID, gc := range mc.delta {
+		if gc == nil {
+			delete(base, gamerID)
+		} else {
+			base[gamerID] = gc
+		}
+	}
+	mc.base = base
+	mc.delta = make(map[uint64]*gamerConstraint)
+}
+
+// 获取快照, 没有修改时复用
+func (mc *matchConstraints) getView() *MatchConstraintView {
+	if mc.view != nil {
+		return mc.view
+	}
+	if len(mc.blocks) <= 0 && len(mc.base) <= 0 && len(mc.delta) <= 0 {
+		return nil
+	}
+	if len(mc.delta) > deltaMergeMin && len(mc.delta) > len(mc.base)/deltaMergeDiv {
+		mc.mergeDelta()
+	}
+	view := &MatchConstraintView{
+		blocks: mc.blocks,
+		base:   mc.base,
+		delta:  make(map[uint64]*gamerConstraint, len(mc.delta)),
+	}
+	for gamerID, gc := range mc.delta {
+		view.delta[gamerID] = gc
+	}
+	mc.blocksShared = true
+	mc.view = view
+	return view
+}
+
+// 修改屏蔽名单前调用, 被快照引用时先复制
+func (mc *matchConstraints) ownBlocks() {
+	if !mc.blocksShared {
+		return
+	}
+	blocks := make(map[gamerPair]struct{}, len(mc.blocks))
+	for pair := range mc.blocks {
+		blocks[pair] = struct{}{}
+	}
+	mc.blocks = blocks
+	mc.blocksShared = false
+}
+
+// 记录一局的对手
+func (mc *matchConstraints) addRecent(gamerID uint64, opponents []uint64, now time.Time) {
+	if mc.recentNum <= 0 || len(opponents) <= 0 {
+		return
+	}
+	gc := mc.copyGamer(gamerID)
+	// 超过N局, 删掉最早的; 旧记录可能被快照引用, 生成新的切片
+	recent, recentLen := gc.recent, gc.recentLen
+	for len(recentLen) >= mc.recentNum {
+		recent = recent[recentLen[0]:]
+		recentLen = recentLen[1:]
+	}
+	gc.recent = make([]uint64, 0, len(recent)+len(opponents))
+	gc.recent = append(append(gc.recent, recent...), opponents...)
+	gc.recentLen = make([]int, 0, len(recentLen)+1)
+	gc.recentLen = append(append(gc.recentLen, recentLen...), len(opponents))
+	gc.recentTime = now
+	mc.setGamer(gamerID, gc)
+}
+
+// 清空玩家的最近对手
+func (mc *matchConstraints) clearRecent(gamerID uint64) {
+	old := mc.gamer(gamerID)
+	if old == nil || len(old.recent) <= 0 {
+		return
+	}
+	mc.setGamer(gamerID, &gamerConstraint{guildID: old.guildID})
+}
+
+// 删掉最近一局已经超过保留时间的最近对手, 每recentExpireGap最多检查一次
+func (mc *matchConstraints) expireRecent(now time.Time) {
+	if mc.recentKeep <= 0 || now.Sub(mc.lastExpire) < recentExpireGap {
+		return
+	}
+	mc.lastExpire = now
+	expired := make([]uint64, 0)
+	checkOne := func(gamerID uint64, gc *gamerConstraint) {
+		if gc != nil && len(gc.recent) > 0 && now.Sub(gc.recentTime) >= mc.recentKeep {
+			expired = append(expired, gamerID)
+		}
+	}
+	for gamerID, gc := range mc.base {
+		if _, ok := mc.delta[gamerID]; !ok {
+			checkOne(gamerID, gc)
+		}
+	}
+	for gamerID, gc := range mc.delta {
+		checkOne(gamerID, gc)
+	}
+	for _, gamerID := range expired {
+		mc.clearRecent(gamerID)
+	}
+}
+
+// 记录一次匹配结果中的对手. 有分边时只记录对面的人, 否则所有人互为对手
+func (mc *matchConstraints) addRecentMatch(result *MatchResult, now time.Time) {
+	if mc.recentNum <= 0 {
+		return
+	}
+	sides := result.Sides
+	if len(sides) <= 0 {
+		sides = []*MatchSide{{Elems: result.Groups}}
+	}
+	sideGamers := make([][]uint64, len(sides))
+	for i, oneSide := range sides {
+		for _, oneElem := range oneSide.Elems {
+			if data, ok := oneElem.ElemData.(*ScoreMatchElemData); ok {
+				for _, oneGamer := range data.Gamers {
+					sideGamers[i] = append(sideGamers[i], oneGamer.GamerID)
+				}
+			}
+		}
+	}
+	for i := range sideGamers {
+		for _, gamerID := range sideGamers[i] {
+			opponents := make([]uint64, 0)
+			for j := range sideGamers {
+				if i != j || len(sideGamers) == 1 {
+					for _, other := range sideGamers[j] {
+						if other != gamerID {
+							opponents = append(opponents, other)
+						}
+					}
+				}
+			}
+			mc.addRecent(gamerID, opponents, now)
+		}
+	}
+}
+
+// 屏蔽两个玩家, 不会匹配到一起
+func (mqm *MatchQueueMgr) AddAvoidPair(a, b uint64) {
+	mqm.constraints.ownBlocks()
+	mqm.constraints.blocks[newGamerPair(a, b)] = struct{}{}
+	mqm.constraints.dirty()
+}
+
+// 取消屏蔽
+func (mqm *MatchQueueMgr) DelAvoidPair(a, b uint64) {
+	mqm.constraints.ownBlocks()
+	delete(mqm.constraints.blocks, newGamerPair(a, b))
+	mqm.constraints.dirty()
+}
+
+// 设置最近多少局的对手不再匹配, <=0关闭并清空记录
+func (mqm *MatchQueueMgr) SetRecentOpponentNum(num int) {
+	mc := mqm.constraints
+	mc.recentNum = num
+	if num > 0 {
+		return
+	}
+	mc.mergeDelta()
+	for gamerID, gc := range mc.base {
+		if len(gc.recent) > 0 {
+			mc.setGamer(gamerID, &gamerConstraint{guildID: gc.guildID})
+		}
+	}
+	mc.dirty()
+}
+
+// 设置最近对手保留多少秒, 最近一局超过该时间整条删掉, 避免不再回来的玩家一直占内存. 默认1800, <=0不过期
+func (mqm *MatchQueueMgr) SetRecentOpponentKeep(keepSecond int64) {
+	mqm.constraints.recentKeep = time.Duration(keepSecond) * time.Second
+}
+
+// 清空某个玩家的最近对手
+func (mqm *MatchQueueMgr) ClearRecentOpponent(gamerID uint64) {
+	mqm.constraints.clearRecent(gamerID)
+}
+
+// 设置玩家公会, guildID为0表示没有公会
+func (mqm *MatchQueueMgr) SetGamerGuild(gamerID uint64, guildID uint64) {
+	mc := mqm.constraints
+	old := mc.gamer(gamerID)
+	if (old == nil && guildID == 0) || (old != nil && old.guildID == guildID) {
+		return
+	}
+	gc := mc.copyGamer(gamerID)
+	gc.guildID = guildID
+	mc.setGamer(gamerID, gc)
+}
+
+// 设置队列是否禁止同公会玩家匹配到一起
+func (mqm *MatchQueueMgr) SetForbidSameGuild(queKey MatchQueueKey, forbid bool) {
+	if forbid {
+		mqm.constraints.guildForbid[queKey] = struct{}{}
+	} else {
+		delete(mqm.constraints.guildForbid, queKey)
+	}
+}
+
+// --------------------------- 匹配线程使用 ---------------------------
+
+// 两个玩家能否匹配到一起
+func (mj *MatchJobBase) CanPairGamer(a, b uint64) bool {
+	return mj.Constraint.CanPair(a, b, mj.ForbidSameGuild)
+}
+
+// 两个elem能否匹配到一起. 组队elem内部不检查
+func (mj *MatchJobBase) CanPairElem(a, b *MatchElem) bool {
+	if mj.Constraint == nil {
+		return true
+	}
+	dataA, okA := a.ElemData.(*ScoreMatchElemData)
+	dataB, okB := b.ElemData.(*ScoreMatchElemData)
+	if !okA || !okB {
+		return true
+	}
+	for i := range dataA.Gamers {
+		for j := range dataB.Gamers {
+			if !mj.CanPairGamer(dataA.Gamers[i].GamerID, dataB.Gamers[j].GamerID) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// elem能否加入group
+func (mj *MatchJobBase) CanJoin(elem *MatchElem, group []*MatchElem) bool {
+	if mj.Constraint == nil {
+		return true
+	}
+	for _, oneElem := range group {
+		if !mj.CanPairElem(elem, oneElem) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package quematch
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mapPointer(m map[uint64]*gamerConstraint) uintptr {
+	return reflect.ValueOf(m).Pointer()
+}
+
+// 每局只加增量, 全量表在快照之间共用; 旧快照不受之后修改影响
+func TestConstraintViewIncremental(t *testing.T) {
+	mc := newMatchConstraints()
+	mc.recentNum = 2
+	for i := uint64(0); i < 1000; i += 2 {
+		mc.addRecent(i, []uint64{i + 1}, testStart)
+	}
+	first := mc.getView()
+	if first.CanPair(0, 1, false) || !first.CanPair(0, 2, false) {
+		t.Fatal("first view does not match records")
+	}
+	mc.addRecent(0, []uint64{2}, testStart)
+	second := mc.getView()
+	if second == first {
+		t.Fatal("view not refreshed after change")
+	}
+	if mapPointer(second.base) != mapPointer(first.base) {
+		t.Fatal("base rebuilt for a single change")
+	}
+	if len(second.delta) != 1 {
+		t.Fatalf("delta=%d, want 1", len(second.delta))
+	}
+	if first.CanPair(0, 2, false) == second.CanPair(0, 2, false) {
+		t.Fatal("old view saw the new record")
+	}
+	// 超过N局删掉最早的, 旧快照仍然能看到
+	mc.addRecent(0, []uint64{4}, testStart)
+	third := mc.getView()
+	if !third.CanPair(0, 1, false) || third.CanPair(0, 2, false) || third.CanPair(4, 0, false) {
+		t.Fatal("recent window not applied")
+	}
+	if first.CanPair(0, 1, false) {
+		t.Fatal("old view lost its record")
+	}
+}
+
+// 屏蔽名单被快照引用后修改会先复制
+func TestConstraintBlocksCopyOnWrite(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	mqm.AddAvoidPair(1, 2)
+	view := mqm.constraints.getView()
+	mqm.DelAvoidPair(1, 2)
+	if view.CanPair(1, 2, false) {
+		t.Fatal("old view lost block")
+	}
+	if view = mqm.constraints.getView(); view != nil && !view.CanPair(1, 2, false) {
+		t.Fatal("new view still blocked")
+	}
+}
+
+// 取消匹配离开不清最近对手, 最近一局超过保留时间后才删掉
+func TestRecentKeptOnLeaveAndExpired(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	clock := NewVirtualClock(testStart)
+	mqm.SetMatchClock(clock)
+	mqm.SetRecentOpponentNum(1)
+	mqm.SetRecentOpponentKeep(120)
+	mqm.constraints.addRecent(1, []uint64{2}, mqm.now())
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	mqm.EnterWaitQueue(queKey, newTestElem(1, 0))
+	mqm.LeaveQueue(testElemKey(1), LeaveReasonCancel)
+	if gc := mqm.constraints.gamer(1); gc == nil || !gc.isRecent(2) {
+		t.Fatal("recent record pruned on cancel")
+	}
+	clock.Advance(90 * time.Second)
+	mqm.constraints.expireRecent(mqm.now())
+	if gc := mqm.constraints.gamer(1); gc == nil || !gc.isRecent(2) {
+		t.Fatal("recent record expired before keep time")
+	}
+	// 离上次检查不到一个间隔不检查
+	clock.Advance(40 * time.Second)
+	mqm.constraints.expireRecent(mqm.now())
+	if mqm.constraints.gamer(1) == nil {
+		t.Fatal("expire checked within gap")
+	}
+	clock.Advance(20 * time.Second)
+	mqm.constraints.expireRecent(mqm.now())
+	if mqm.constraints.gamer(1) != nil {
+		t.Fatal("recent record not expired")
+	}
+}
+
+// 上一局的对手再进队不会马上又匹配到一起
+func TestRecentOpponentAvoided(t *testing.T) {
+	trace := testTrace(MatchStrategyNormal, 0, newTestElem(1, 0), newTestElem(2, 0))
+	trace = append(trace, testTraceInOrder(MatchStrategyNormal,
+		newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0))...)
+	for i := 2; i < len(trace); i++ {
+		trace[i].Offset += 2 * time.Second
+	}
+	setup := func(coll *MatchDataCollector) {
+		coll.GetMatchMgr().SetRecentOpponentNum(1)
+	}
+	results := simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 4*time.Second, setup)
+	if len(results) != 2 {
+		t.Fatalf("results=%d, want 2", len(results))
+	}
+	checkResultElems(t, results[0], 1, 2)
+	checkResultElems(t, results[1], 1, 3)
+}
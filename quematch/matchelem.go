@@ -22,9 +22,45 @@ type iElemData interface {
 	GamerNum() int
 }
 
+// 离开匹配队列原因
+type MatchLeaveReason uint32
+
+const (
+	LeaveReasonSuccess     MatchLeaveReason = iota // 匹配/增补成功
+	LeaveReasonCancel                              // 玩家主动取消
+	LeaveReasonTimeout                             // 等待超时
+	LeaveReasonReenter                             // 重新进入匹配(EnterWaitQueue)
+	LeaveReasonTeamRequeue                         // 组队成员随队伍重新进入匹配
+	LeaveReasonShutdown                            // 匹配服关闭
+	LeaveReasonKick                                // 管理员踢出
+	LeaveReasonReadyRefuse                         // 匹配确认拒绝或超时
+	LeaveReasonQueueRemove                         // 队列被删除
+	LeaveReasonSuspend                             // 匹配服关闭, 已保存快照, 重启后会恢复进队
+)
+
+var leaveReasonName = map[MatchLeaveReason]string{
+	LeaveReasonSuccess:     "success",
+	LeaveReasonCancel:      "cancel",
+	LeaveReasonTimeout:     "timeout",
+	LeaveReasonReenter:     "reenter",
+	LeaveReasonTeamRequeue: "team_requeue",
+	LeaveReasonShutdown:    "shutdown",
+	LeaveReasonKick:        "kick",
+	LeaveReasonReadyRefuse: "ready_refuse",
+	LeaveReasonQueueRemove: "queue_remove",
+	LeaveReasonSuspend:     "suspend",
+}
+
+func (r MatchLeaveReason) String() string {
+	if name, ok := leaveReasonName[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
 type IElemFunc interface {
 	OnEnterQueue(MatchQueueKey, *MatchElem)
-	OnLeaveQueue(MatchQueueKey, *MatchElem, bool)
+	OnLeaveQueue(MatchQueueKey, *MatchElem, MatchLeaveReason)
 }
 
 type MatchElem struct {
@@ -32,11 +68,21 @@ type MatchElem struct {
 	ElemKey   MatchElemKey
 	StartTime time.Time
 	ElemData  iElemData
+	Lane      MatchLane // 优先通道
+	Priority  int32     // 单元优先级, 每1点当作多等1秒
+
+	boostSecond int64       // 进队时计算的提前秒数
+	clock       IMatchClock // 所在mgr的时钟, 进队时设置, nil按真实时间
+	frozen      *MatchElem  // 只读副本, 给匹配线程使用. UpdateMatchElem修改后重新生成
 }
 
 // 已经等待的时间.单位: 秒
 func (me *MatchElem) WaitSecond() int64 {
-	return int64(time.Now().Sub(me.StartTime)) / int64(time.Second)
+	clock := me.clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	return int64(clock.Now().Sub(me.StartTime)) / int64(time.Second)
 }
 
 func (me *MatchElem) allTypeKey() []*MatchElemKey {
@@ -62,9 +108,20 @@ func (me *MatchElem) allTypeKey() []*MatchElemKey {
 func (me *MatchElem) clone() *MatchElem {
 	cloneElem := NewMatchElem(me.ElemKey, me.ElemData.clone(), me.IElemFunc)
 	cloneElem.StartTime = me.StartTime
+	cloneElem.Lane = me.Lane
+	cloneElem.Priority = me.Priority
+	cloneElem.boostSecond = me.boostSecond
+	cloneElem.clock = me.clock
 	return cloneElem
 }
 
+// 生成只读副本. 已有副本时不重复生成, 多队列elem共用一个副本
+func (me *MatchElem) freeze() {
+	if me.frozen == nil {
+		me.frozen = me.clone()
+	}
+}
+
 // new matchElem
 func NewMatchElem(key MatchElemKey, data iElemData, elemFunc IElemFunc) *MatchElem {
 	return &MatchElem{
@@ -85,6 +142,9 @@ type IScoreMatchGamerExt interface {
 // 分数匹配玩家
 type ScoreMatchGamer struct {
 	GamerID   uint64
+	Score     int32            // 匹配分(MMR/ELO)
+	Roles     []MatchRole      // 可选职业, 职业匹配时使用
+	Latency   map[uint32]int32 // 区域 -> 延迟(毫秒), 区域延迟匹配时使用
 	GamerData IScoreMatchGamerExt
 }
 
@@ -99,6 +159,15 @@ func (smed *ScoreMatchElemData) clone() iElemData {
 	cloneData.Gamers = make([]ScoreMatchGamer, len(smed.Gamers))
 	copy(cloneData.Gamers, smed.Gamers)
 	for i := 0; i < len(smed.Gamers); i++ {
+		if smed.Gamers[i].Roles != nil {
+			cloneData.Gamers[i].Roles = append([]MatchRole(nil), smed.Gamers[i].Roles...)
+		}
+		if smed.Gamers[i].Latency != nil {
+			cloneData.Gamers[i].Latency = make(map[uint32]int32, len(smed.Gamers[i].Latency))
+			for region, latency := range smed.Gamers[i].Latency {
+				cloneData.Gamers[i].Latency[region] = latency
+			}
+		}
 		if smed.Gamers[i].GamerData != nil {
 			cloneData.Gamers[i].GamerData = smed.Gamers[i].GamerData.Clone()
 		}
@@ -110,6 +179,59 @@ func (smed *ScoreMatchElemData) GamerNum() int {
 	return len(smed.Gamers)
 }
 
+// 总分
+func (smed *ScoreMatchElemData) TotalScore() int64 {
+	var total int64
+	for i := 0; i < len(smed.Gamers); i++ {
+		total += int64(smed.Gamers[i].Score)
+	}
+	return total
+}
+
+// 平均分. 组队时用平均分代表整个单元
+func (smed *ScoreMatchElemData) AvgScore() int32 {
+	if len(smed.Gamers) <= 0 {
+		return 0
+	}
+	return int32(smed.TotalScore() / int64(len(smed.Gamers)))
+}
+
+// 单元内到region的最大延迟, 有玩家没有该区域延迟时返回false.
+// 所有玩家都没上报延迟时当作延迟为0
+func (smed *ScoreMatchElemData) maxLatency(region uint32) (int32, bool) {
+	var maxLatency int32
+	for i := 0; i < len(smed.Gamers); i++ {
+		if smed.Gamers[i].Latency == nil {
+			continue
+		}
+		latency, ok := smed.Gamers[i].Latency[region]
+		if !ok {
+			return 0, false
+		}
+		if latency > maxLatency {
+			maxLatency = latency
+		}
+	}
+	return maxLatency, true
+}
+
+// 单元内最低分和最高分
+func (smed *ScoreMatchElemData) scoreRange() (int32, int32) {
+	if len(smed.Gamers) <= 0 {
+		return 0, 0
+	}
+	minScore, maxScore := smed.Gamers[0].Score, smed.Gamers[0].Score
+	for i := 1; i < len(smed.Gamers); i++ {
+		if smed.Gamers[i].Score < minScore {
+			minScore = smed.Gamers[i].Score
+		}
+		if smed.Gamers[i].Score > maxScore {
+			maxScore = smed.Gamers[i].Score
+		}
+	}
+	return minScore, maxScore
+}
+
 // new
 func NewScoreMatchElemData() *ScoreMatchElemData {
 	return &ScoreMatchElemData{
@@ -0,0 +1,114 @@
+package quematch
+
+/*
+	matchelemqueue.go: 带索引的匹配elem队列
+	双向链表保持进队顺序, map索引节点. 查找/删除/入队都是O(1)
+*/
+
+type elemQueueNode struct {
+	elem *MatchElem
+	prev *elemQueueNode
+	next *elemQueueNode
+}
+
+type elemQueue struct {
+	head  *elemQueueNode
+	tail  *elemQueueNode
+	index map[MatchElemKey]*elemQueueNode
+}
+
+func newElemQueue() *elemQueue {
+	return &elemQueue{
+		index: make(map[MatchElemKey]*elemQueueNode),
+	}
+}
+
+func (eq *elemQueue) len() int {
+	return len(eq.index)
+}
+
+// 加到队尾, key已存在时返回false
+func (eq *elemQueue) pushBack(elem *MatchElem) bool {
+	if _, ok := eq.index[elem.ElemKey]; ok {
+		return false
+	}
+	node := &elemQueueNode{elem: elem, prev: eq.tail}
+	if eq.tail != nil {
+		eq.tail.next = node
+	} else {
+		eq.head = node
+	}
+	eq.tail = node
+	eq.index[elem.ElemKey] = node
+	return true
+}
+
+func (eq *elemQueue) find(elemKey MatchElemKey) *MatchElem {
+	node, ok := eq.index[elemKey]
+	if !ok {
+		return nil
+	}
+	return node.elem
+}
+
+func (eq *elemQueue) remove(elemKey MatchElemKey) *MatchElem {
+	node, ok := eq.index[elemKey]
+	if !ok {
+		return nil
+	}
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		eq.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		eq.tail = node.prev
+	}
+	node.prev, node.next = nil, nil
+	delete(eq.index, elemKey)
+	return node.elem
+}
+
+// 按StartTime插到原来的位置, 从队头往后找. 用于暂时移出的elem放回. key已存在时返回false
+func (eq *elemQueue) insertByStart(elem *MatchElem) bool {
+	if _, ok := eq.index[elem.ElemKey]; ok {
+		return false
+	}
+	next := eq.head
+	for next != nil && !next.elem.StartTime.After(elem.StartTime) {
+		next = next.next
+	}
+	if next == nil {
+		return eq.pushBack(elem)
+	}
+	node := &elemQueueNode{elem: elem, prev: next.prev, next: next}
+	if next.prev != nil {
+		next.prev.next = node
+	} else {
+		eq.head = node
+	}
+	next.prev = node
+	eq.index[elem.ElemKey] = node
+	return true
+}
+
+// 最早进队的elem
+func (eq *elemQueue) front() *MatchElem {
+	if eq.head == nil {
+		return nil
+	}
+	return eq.head.elem
+}
+
+// 按进队顺序遍历, runFunc返回false停止
+func (eq *elemQueue) foreach(runFunc func(elem *MatchElem) bool) {
+	for node := eq.head; node != nil; {
+		next := node.next
+		if !runFunc(node.elem) {
+			return
+		}
+		node = next
+	}
+}
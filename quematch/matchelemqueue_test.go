@@ -0,0 +1,107 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xlog"
+	"testing"
+)
+
+// 大队列下进队、出队、查找和处理匹配结果的耗时
+
+const benchQueueLen = 100000
+
+var benchQueKey = MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+
+func newBenchElem(id uint64) *MatchElem {
+	data := NewScoreMatchElemData()
+	data.Gamers = append(data.Gamers, ScoreMatchGamer{GamerID: id})
+	return NewMatchElem(MatchElemKey{ElemType: MatchElemPerson, ElemID: id}, data, &testElemFunc{})
+}
+
+// 新建模拟用的mgr, 队列中先放入elemNum个elem
+func newBenchQueue(b *testing.B, elemNum int) (*MatchDataCollector, []*MatchElem) {
+	b.Helper()
+	// 只打error, 避免统计到打log的耗时
+	logLevel := xlog.LogLevel
+	xlog.LogLevel = 4
+	b.Cleanup(func() {
+		xlog.LogLevel = logLevel
+	})
+	coll := NewSimDataCollector(&testCollOK{}, testStart, 0)
+	if coll == nil {
+		b.Fatal("NewSimDataCollector failed")
+	}
+	coll.InitClientMapInfo(ClientKey{ServerID: 1}, MapInfo{MapID: testMapID, MatchTotalNeed: 2})
+	elems := make([]*MatchElem, 0, elemNum)
+	for i := 0; i < elemNum; i++ {
+		oneElem := newBenchElem(uint64(i + 1))
+		if !coll.GetMatchMgr().EnterWaitQueue(benchQueKey, oneElem) {
+			b.Fatal("EnterWaitQueue failed")
+		}
+		elems = append(elems, oneElem)
+	}
+	return coll, elems
+}
+
+// 从中间往两边取, 最接近随机删除的情况
+func benchMiddleIdx(elemNum, i int) int {
+	return elemNum/2 + (i+1)/2*(1-2*(i%2))
+}
+
+func BenchmarkEnter(b *testing.B) {
+	coll, _ := newBenchQueue(b, benchQueueLen)
+	defer coll.EndSimulation()
+	elems := make([]*MatchElem, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		elems = append(elems, newBenchElem(uint64(benchQueueLen+i+1)))
+	}
+	mqm := coll.GetMatchMgr()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mqm.EnterWaitQueue(benchQueKey, elems[i])
+	}
+}
+
+func BenchmarkLeave(b *testing.B) {
+	elemNum := benchQueueLen + b.N
+	coll, elems := newBenchQueue(b, elemNum)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mqm.LeaveQueue(elems[benchMiddleIdx(elemNum, i)].ElemKey, LeaveReasonCancel)
+	}
+}
+
+func BenchmarkFind(b *testing.B) {
+	coll, elems := newBenchQueue(b, benchQueueLen)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if oneElem, _ := mqm.FindMatchElem(elems[i%benchQueueLen].ElemKey); oneElem == nil {
+			b.Fatal("elem not found")
+		}
+	}
+}
+
+// 每次处理一个2人的匹配结果, 结果中的elem在队列中间
+func BenchmarkDoReturn(b *testing.B) {
+	elemNum := benchQueueLen + 2*b.N
+	coll, elems := newBenchQueue(b, elemNum)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	matchQue := mqm.findMatchQueue(benchQueKey)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newTestMatchJob(mqm, benchQueKey,
+			[]*MatchElem{elems[benchMiddleIdx(elemNum, 2*i)], elems[benchMiddleIdx(elemNum, 2*i+1)]}).DoReturn()
+	}
+	b.StopTimer()
+	if matchQue.getElemLen() != benchQueueLen {
+		b.Fatalf("queue len=%d, want %d", matchQue.getElemLen(), benchQueueLen)
+	}
+}
@@ -0,0 +1,173 @@
+package quematch
+
+import (
+	"time"
+)
+
+/*
+	matchestimate.go: 统计每个队列最近的匹配吞吐和等待时长, 估算剩余等待时间
+*/
+
+// 最近统计记录条数
+const queueStatRecordNum = 64
+
+// 一次成功匹配记录
+type matchRecord struct {
+	matchTime time.Time // 匹配成功时间
+	gamerNum  int       // 匹配走的人数
+	waitTotal int64     // 所有elem等待秒数之和
+	elemNum   int       // elem数量
+}
+
+// 队列统计
+type queueStat struct {
+	records  []matchRecord // 环形记录
+	writeIdx int
+	counter  queueCounter // 性能计数
+}
+
+func newQueueStat() *queueStat {
+	return &queueStat{
+		records: make([]matchRecord, 0, queueStatRecordNum),
+		counter: newQueueCounter(),
+	}
+}
+
+func (qs *queueStat) addRecord(record matchRecord) {
+	if len(qs.records) < queueStatRecordNum {
+		qs.records = append(qs.records, record)
+		return
+	}
+	qs.records[qs.writeIdx] = record
+	qs.writeIdx = (qs.writeIdx + 1) % queueStatRecordNum
+}
+
+// 最近平均等待秒数, 没有记录返回-1
+func (qs *queueStat) avgWait() int64 {
+	var waitTotal int64
+	elemNum := 0
+	for _, oneRecord := range qs.records {
+		waitTotal += oneRecord.waitTotal
+		elemNum += oneRecord.elemNum
+	}
+	if elemNum <= 0 {
+		return -1
+	}
+	return waitTotal / int64(elemNum)
+}
+
+// 最近每秒匹配走的人数, 记录不足返回0
+func (qs *queueStat) throughput(now time.Time) float64 {
+	if len(qs.records) < 2 {
+		return 0
+	}
+	oldest := qs.records[0].matchTime
+	gamerNum := 0
+	for _, oneRecord := range qs.records {
+		if oneRecord.matchTime.Before(oldest) {
+			oldest = oneRecord.matchTime
+		}
+		gamerNum += oneRecord.gamerNum
+	}
+	span := now.Sub(oldest).Seconds()
+	if span <= 0 {
+		return 0
+	}
+	return float64(gamerNum) / span
+}
+
+// 排队信息
+type QueueWaitInfo struct {
+	QueKey         MatchQueueKey // 所在队列
+	Position       int           // 队列中的位置, 从1开始
+	AheadGamerNum  int           // 排在前面的人数(含自己)
+	WaitSecond     int64         // 已经等待秒数
+	EstimateSecond int64         // 估算剩余等待秒数, -1表示暂无数据
+}
+
+func (mqm *MatchQueueMgr) getQueueStat(queKey MatchQueueKey) *queueStat {
+	stat, ok := mqm.queStats[queKey]
+	if !ok {
+		stat = newQueueStat()
+		mqm.queStats[queKey] = stat
+	}
+	return stat
+}
+
+// 记录一次成功匹配/增补
+func (mqm *MatchQueueMgr) recordMatched(queKey MatchQueueKey, result *MatchResult, isSupply bool) {
+	stat := mqm.getQueueStat(queKey)
+	record := matchRecord{
+		matchTime: mqm.now(),
+	}
+	result.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+		record.gamerNum += oneElem.ElemData.GamerNum()
+		record.waitTotal += oneElem.WaitSecond()
+		record.elemNum++
+		stat.counter.matchWait.observe(mqm.since(oneElem.StartTime).Seconds())
+	})
+	stat.addRecord(record)
+	if isSupply {
+		stat.counter.supplyNum++
+	} else {
+		stat.counter.matchNum++
+	}
+}
+
+// 队列最近平均匹配等待秒数, 没有数据返回-1
+func (mqm *MatchQueueMgr) GetQueueAvgWait(queKey MatchQueueKey) int64 {
+	stat, ok := mqm.queStats[queKey]
+	if !ok {
+		return -1
+	}
+	return stat.avgWait()
+}
+
+// 获取elem的排队位置和估算剩余等待时间
+func (mqm *MatchQueueMgr) GetQueueWaitInfo(elemKey MatchElemKey) (QueueWaitInfo, bool) {
+	queKey := mqm.findQueKeyByElemKey(elemKey)
+	if queKey == nil {
+		return QueueWaitInfo{}, false
+	}
+	matchQue := mqm.findMatchQueue(*queKey)
+	if matchQue == nil {
+		return QueueWaitInfo{}, false
+	}
+	elem := matchQue.findMatch(elemKey)
+	if elem == nil {
+		return QueueWaitInfo{}, false
+	}
+	info := QueueWaitInfo{
+		QueKey:         *queKey,
+		WaitSecond:     elem.WaitSecond(),
+		EstimateSecond: -1,
+	}
+	// 按优先级排序后的位置, 排序时间相同时先进队的在前
+	beforeSelf := true
+	matchQue.matchElems.foreach(func(oneElem *MatchElem) bool {
+		if oneElem == elem {
+			beforeSelf = false
+		}
+		if oneElem == elem || oneElem.OrderTime().Before(elem.OrderTime()) ||
+			(beforeSelf && oneElem.OrderTime().Equal(elem.OrderTime())) {
+			info.Position++
+			info.AheadGamerNum += oneElem.ElemData.GamerNum()
+		}
+		return true
+	})
+
+	stat, ok := mqm.queStats[*queKey]
+	if !ok {
+		return info, true
+	}
+	// 优先按吞吐估算, 没有吞吐数据时按平均等待时间估算
+	if speed := stat.throughput(mqm.now()); speed > 0 {
+		info.EstimateSecond = int64(float64(info.AheadGamerNum) / speed)
+	} else if avgWait := stat.avgWait(); avgWait >= 0 {
+		info.EstimateSecond = avgWait - info.WaitSecond
+		if info.EstimateSecond < 0 {
+			info.EstimateSecond = 0
+		}
+	}
+	return info, true
+}
@@ -0,0 +1,90 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 记录超过64条后覆盖最早的, 吞吐按剩下记录中最早的时间算
+func TestQueueStatRecordWrap(t *testing.T) {
+	qs := newQueueStat()
+	total := queueStatRecordNum + 10
+	for i := 0; i < total; i++ {
+		qs.addRecord(matchRecord{
+			matchTime: testStart.Add(time.Duration(i) * time.Second),
+			gamerNum:  1,
+			waitTotal: int64(i),
+			elemNum:   1,
+		})
+	}
+	if len(qs.records) != queueStatRecordNum || qs.writeIdx != 10 {
+		t.Fatalf("records=%d, writeIdx=%d, want %d and 10", len(qs.records), qs.writeIdx, queueStatRecordNum)
+	}
+	// 剩下第10到73条, 平均等待(10+73)/2
+	if avg := qs.avgWait(); avg != 41 {
+		t.Fatalf("avgWait=%d, want 41", avg)
+	}
+	now := testStart.Add(time.Duration(total) * time.Second)
+	if speed := qs.throughput(now); speed != 1 {
+		t.Fatalf("throughput=%f, want 1", speed)
+	}
+}
+
+// 没有匹配记录时平均等待为-1
+func TestGetQueueAvgWaitEmpty(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	if avg := mqm.GetQueueAvgWait(queKey); avg != -1 {
+		t.Fatalf("avg of unknown queue=%d, want -1", avg)
+	}
+	mqm.EnterWaitQueue(queKey, newTestElem(1, 0))
+	mqm.LeaveQueue(testElemKey(1), LeaveReasonCancel)
+	if avg := mqm.GetQueueAvgWait(queKey); avg != -1 {
+		t.Fatalf("avg without match=%d, want -1", avg)
+	}
+}
+
+// 排队位置按优先级算, 估算优先按吞吐, 没有吞吐数据按平均等待
+func TestGetQueueWaitInfo(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	clock := NewVirtualClock(testStart)
+	mqm.SetMatchClock(clock)
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	for _, oneElem := range []*MatchElem{newTestElem(1, 0), newTestElem(2, 0, 0), newTestElem(3, 0)} {
+		mqm.EnterWaitQueue(queKey, oneElem)
+		clock.Advance(10 * time.Second)
+	}
+	clock.Advance(10 * time.Second)
+	info, ok := mqm.GetQueueWaitInfo(testElemKey(3))
+	if !ok || info.QueKey != queKey {
+		t.Fatal("GetQueueWaitInfo failed")
+	}
+	if info.Position != 3 || info.AheadGamerNum != 4 || info.WaitSecond != 20 || info.EstimateSecond != -1 {
+		t.Fatalf("info=%+v, want position 3, ahead 4, wait 20, estimate -1", info)
+	}
+	// 优先级高的后进队也排在前面
+	boost := newTestElem(4, 0)
+	boost.Priority = 100
+	mqm.EnterWaitQueue(queKey, boost)
+	if info, _ = mqm.GetQueueWaitInfo(boost.ElemKey); info.Position != 1 || info.AheadGamerNum != 1 {
+		t.Fatalf("boost info=%+v, want position 1", info)
+	}
+	if info, _ = mqm.GetQueueWaitInfo(testElemKey(3)); info.Position != 4 || info.AheadGamerNum != 5 {
+		t.Fatalf("info after boost=%+v, want position 4, ahead 5", info)
+	}
+	mqm.LeaveQueue(boost.ElemKey, LeaveReasonCancel)
+	// 只有一条记录按平均等待: 30-20
+	stat := mqm.getQueueStat(queKey)
+	stat.addRecord(matchRecord{matchTime: testStart.Add(20 * time.Second), gamerNum: 5, waitTotal: 60, elemNum: 2})
+	if info, _ = mqm.GetQueueWaitInfo(testElemKey(3)); info.EstimateSecond != 10 {
+		t.Fatalf("estimate by avg wait=%d, want 10", info.EstimateSecond)
+	}
+	if info, _ = mqm.GetQueueWaitInfo(testElemKey(1)); info.EstimateSecond != 0 {
+		t.Fatalf("estimate past avg wait=%d, want 0", info.EstimateSecond)
+	}
+	// 20秒走了10人, 每秒0.5人, 前面4人要8秒
+	stat.addRecord(matchRecord{matchTime: testStart.Add(30 * time.Second), gamerNum: 5, waitTotal: 60, elemNum: 2})
+	if info, _ = mqm.GetQueueWaitInfo(testElemKey(3)); info.EstimateSecond != 8 {
+		t.Fatalf("estimate by throughput=%d, want 8", info.EstimateSecond)
+	}
+}
@@ -0,0 +1,63 @@
+package quematch
+
+import (
+	"math"
+)
+
+/*
+	matchexpand.go: 按等待时间扩大分数搜索范围
+	等得越久, 允许的分差越大. 用质量换速度, 但有上限
+*/
+
+// 扩展曲线类型
+type ExpandCurve uint32
+
+const (
+	ExpandCurveLinear ExpandCurve = iota // 线性: 每StepSecond秒平滑增加StepRange
+	ExpandCurveStep                      // 阶梯: 每满StepSecond秒增加一次StepRange
+	ExpandCurveExp                       // 指数: 每StepSecond秒乘以ExpFactor
+)
+
+// 搜索范围扩展配置
+type ScoreExpandCfg struct {
+	Curve      ExpandCurve // 曲线类型
+	BaseRange  int32       // 初始允许分差
+	StepRange  int32       // 线性/阶梯每步增加的分差
+	StepSecond int64       // 每步秒数, <=0当1秒处理
+	ExpFactor  float64     // 指数曲线倍率
+	MaxRange   int32       // 允许分差上限, <=0表示不限
+}
+
+// 等待waitSecond秒后允许的分差
+func (cfg *ScoreExpandCfg) Range(waitSecond int64) int32 {
+	if waitSecond < 0 {
+		waitSecond = 0
+	}
+	stepSecond := cfg.StepSecond
+	if stepSecond <= 0 {
+		stepSecond = 1
+	}
+	expand := float64(cfg.BaseRange)
+	switch cfg.Curve {
+	case ExpandCurveLinear:
+		expand += float64(cfg.StepRange) * float64(waitSecond) / float64(stepSecond)
+	case ExpandCurveStep:
+		expand += float64(cfg.StepRange) * float64(waitSecond/stepSecond)
+	case ExpandCurveExp:
+		if cfg.ExpFactor > 0 {
+			expand *= math.Pow(cfg.ExpFactor, float64(waitSecond)/float64(stepSecond))
+		}
+	}
+	if cfg.MaxRange > 0 && expand > float64(cfg.MaxRange) {
+		return cfg.MaxRange
+	}
+	if expand > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(expand)
+}
+
+// 某个匹配元素当前允许的分差
+func (cfg *ScoreExpandCfg) ElemRange(elem *MatchElem) int32 {
+	return cfg.Range(elem.WaitSecond())
+}
@@ -1,20 +1,22 @@
 package quematch
 
 import (
+	"context"
 	"github.com/qixi7/xengine_core/xcontainer/job"
 	"github.com/qixi7/xengine_core/xlog"
 	"github.com/qixi7/xengine_core/xmodule"
+	"time"
 )
 
 // 多线程匹配基于xcontainer/job
 
-// 匹配实现接口
+// 匹配实现接口. base.QueElems中的elem是多个job共用的只读副本, DoThreadMatch不能修改elem, 只能排序切片
 type IMatchAchieve interface {
 	DoThreadMatch(base *MatchJobBase)
 	CreateNewSelf() IMatchAchieve
 }
 
-// 增补实现接口
+// 增补实现接口. 和IMatchAchieve一样, DoThreadSupply不能修改base.QueElems中的elem
 type ISupplyAchieve interface {
 	DoThreadSupply(base *SupplyJobBase)
 	CreateNewSelf() ISupplyAchieve
@@ -44,18 +46,45 @@ type MatchJobBase struct {
 	matchMgrGetter xmodule.DModuleGetter // 匹配mgr getter
 	cliKey         ClientKey             // client服务器key
 
-	QueKey    MatchQueueKey // 匹配队列key
-	QueMap    MapInfo       // 地图ID信息
-	QueElems  []*MatchElem  // 匹配elem
-	QueResult *MatchResult  // 匹配结果
+	QueKey      MatchQueueKey  // 匹配队列key
+	QueMap      MapInfo        // 地图ID信息
+	QueElems    []*MatchElem   // 匹配elem. 切片每个job一份可以排序; elem是队列elem的只读副本, 多个job共用, 不能修改
+	QueResult   *MatchResult   // 匹配结果
+	QueResults  []*MatchResult // 所有独立的匹配结果, [0]即QueResult
+	MaxMatchNum int            // 一次最多输出多少个匹配结果
+
+	Constraint      *MatchConstraintView // 匹配约束快照, 只读. 没有约束时为nil
+	ForbidSameGuild bool                 // 是否禁止同公会
+	Region          uint32               // client服务器所在区域
+	LatencyLimit    *LatencyExpandCfg    // 允许延迟扩展配置, nil不限制
+
+	Ctx     context.Context // 超时或取消后Err()不为nil, DoThreadMatch应尽早退出
+	seq     uint64          // job序号, 用于丢弃超时的结果
+	jobCost time.Duration   // DoThreadMatch耗时
 }
 
 func newMatchJob(ach IMatchAchieve) *MatchJobBase {
+	result := NewMatchResult()
 	return &MatchJobBase{
 		IMatchAchieve: ach,
-		QueElems:      make([]*MatchElem, 0),
-		QueResult:     NewMatchResult(),
+		QueResult:     result,
+		QueResults:    []*MatchResult{result},
+	}
+}
+
+// 新增一个独立的匹配结果, 每个结果单独检查和回调MatchSuccess
+func (mj *MatchJobBase) AddMatchResult() *MatchResult {
+	result := NewMatchResult()
+	mj.QueResults = append(mj.QueResults, result)
+	return result
+}
+
+// 获取第idx个匹配结果, 不够时补上
+func (mj *MatchJobBase) ResultAt(idx int) *MatchResult {
+	for len(mj.QueResults) <= idx {
+		mj.AddMatchResult()
 	}
+	return mj.QueResults[idx]
 }
 
 // 主线程使用
@@ -69,54 +98,140 @@ func (mj *MatchJobBase) init(mgrGetter xmodule.DModuleGetter, queKey MatchQueueK
 	mj.cliKey = cliKey
 	mj.QueKey = queKey
 	mj.QueMap = mapInfo
-	elemQueue := matchQue.copyCanMatchElems()
-	mj.QueElems = append(mj.QueElems, elemQueue...)
+	queMgr := mj.getMatchQueueMgr()
+	mj.MaxMatchNum = int(queMgr.baseCfg.MaxMatchPerJob)
+	if mj.MaxMatchNum <= 0 {
+		mj.MaxMatchNum = 1
+	}
+	mj.Constraint = queMgr.constraints.getView()
+	_, mj.ForbidSameGuild = queMgr.constraints.guildForbid[queKey]
+	if cliInfo, ok := queMgr.matchClientInfo[cliKey]; ok {
+		mj.Region = cliInfo.load.Region
+	}
+	if queMgr.latencyLimit != nil {
+		limit := *queMgr.latencyLimit
+		mj.LatencyLimit = &limit
+	}
+	mj.QueElems = copyElemView(matchQue.canMatchView())
 	return len(mj.QueElems) > 0
 }
 
+// 复制一份视图切片给job, elem副本仍然共用
+func copyElemView(view []*MatchElem) []*MatchElem {
+	elems := make([]*MatchElem, len(view))
+	copy(elems, view)
+	return elems
+}
+
+// 把结果中的只读副本换回队列中的elem. 回调业务时给业务自己的elem, 不和匹配线程共用
+func (mqm *MatchQueueMgr) liveResultElems(result *MatchResult) {
+	for i, oneElem := range result.Groups {
+		if liveElem, _ := mqm.findMatchElem(oneElem.ElemKey); liveElem != nil {
+			result.Groups[i] = liveElem
+		}
+	}
+	for _, oneSide := range result.Sides {
+		for i, oneElem := range oneSide.Elems {
+			if liveElem, _ := mqm.findMatchElem(oneElem.ElemKey); liveElem != nil {
+				oneSide.Elems[i] = liveElem
+			}
+		}
+	}
+}
+
 func (mj *MatchJobBase) DoJob() job.Done {
+	startTime := time.Now()
+	mj.filterLatency()
 	mj.DoThreadMatch(mj)
+	mj.jobCost = time.Since(startTime)
 	return mj
 }
 
 func (mj *MatchJobBase) DoReturn() {
 	queMgr := mj.getMatchQueueMgr()
-	matchQue := queMgr.findMatchQueue(mj.QueKey)
-	if matchQue == nil {
-		xlog.Errorf("<queue_match> match return no queueKey=%v", mj.QueKey)
+	// 超时/取消/队列已删除, 丢弃结果
+	if !queMgr.acceptJobReturn(mj.QueKey, mj.Ctx, mj.seq) {
 		return
 	}
-	matchQue.inMatch = false
-	groupLen := len(mj.QueResult.Groups)
-	// 匹配结果为空, 说明匹配失败
-	if groupLen <= 0 {
-		return
+	queStat := queMgr.getQueueStat(mj.QueKey)
+	queStat.counter.jobCost.observe(mj.jobCost.Seconds())
+	// 每个匹配结果单独检查, 一个结果失效不影响其他结果
+	used := make(map[MatchElemKey]struct{})
+	dispatchNum := 0
+	for _, oneResult := range mj.QueResults {
+		// 匹配结果为空, 说明匹配失败
+		if len(oneResult.Groups) <= 0 {
+			continue
+		}
+		// 保底检查一下这些匹配元素是否存在. 避免幽灵匹配
+		if !mj.checkResult(queMgr, oneResult, used) {
+			queStat.counter.ghostReject++
+			continue
+		}
+		// 第一局用投递job时选好的client服务器, 之后每局重新选并计入负载
+		cliKey := mj.cliKey
+		if dispatchNum > 0 {
+			oneClient := queMgr.pickResultClient(oneResult, &mj.QueMap)
+			if oneClient == nil {
+				xlog.InfoF("<queue_match> no client for match result, queKey=%v", mj.QueKey)
+				continue
+			}
+			oneClient.load.CurPlayerNum += mj.QueMap.MatchTotalNeed
+			cliKey = oneClient.key
+		}
+		dispatchNum++
+		oneResult.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+			used[oneElem.ElemKey] = struct{}{}
+		})
+		queMgr.liveResultElems(oneResult)
+		mj.dispatchResult(queMgr, oneResult, cliKey)
 	}
+}
 
-	// 保底检查一下这些匹配元素是否存在. 避免幽灵匹配
-	allElemExist := true
-	mj.QueResult.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
-		if findElem, _ := queMgr.FindMatchElem(oneElem.ElemKey); findElem == nil {
-			allElemExist = false
-			return
-		}
-	})
-	if !allElemExist {
+// 检查结果中的elem是否都还在队列中且没有被前面的结果用掉, 有缺失时尝试修补
+func (mj *MatchJobBase) checkResult(queMgr *MatchQueueMgr, result *MatchResult,
+	used map[MatchElemKey]struct{}) bool {
+	lost := lostResultElems(queMgr, result, used)
+	if len(lost) <= 0 {
+		return true
+	}
+	repair, ok := mj.IMatchAchieve.(IMatchRepair)
+	if !ok {
+		return false
+	}
+	if !repair.RepairMatch(mj, result, lost, mj.repairPool(queMgr, result, used)) {
+		return false
+	}
+	if len(lostResultElems(queMgr, result, used)) > 0 {
+		return false
+	}
+	queMgr.getQueueStat(mj.QueKey).counter.matchRepair++
+	return true
+}
+
+// 匹配成功处理
+func (mj *MatchJobBase) dispatchResult(queMgr *MatchQueueMgr, result *MatchResult, cliKey ClientKey) {
+	// 开启匹配确认时先确认, 确认通过后再回调MatchSuccess
+	if queMgr.readyCheckOn() {
+		queMgr.startReadyCheck(mj.QueKey, cliKey, mj.QueMap, result)
 		return
 	}
 	// 匹配成功回调
-	allok := queMgr.successDo.MatchSuccess(mj.QueResult, mj.cliKey, mj.QueMap)
+	allok := queMgr.successDo.MatchSuccess(result, cliKey, mj.QueMap)
 	if !allok {
+		queMgr.getQueueStat(mj.QueKey).counter.successRefuse++
 		return
 	}
+	queMgr.recordMatched(mj.QueKey, result, false)
+	queMgr.constraints.addRecentMatch(result, queMgr.now())
 	// log
-	xlog.InfoF("<queue_match> match success queKey=%v, result:", mj.QueKey)
-	mj.QueResult.ForeachMatchElem(func(oneElem *MatchElem, elemIdx int) {
+	xlog.InfoF("<queue_match> match success queKey=%v, cliKey=%v, result:", mj.QueKey, cliKey)
+	result.ForeachMatchElem(func(oneElem *MatchElem, elemIdx int) {
 		xlog.InfoF("\t<queue_match> elemIdx=%d, elem=%v", elemIdx, *oneElem)
 	})
 	// 离开匹配
-	mj.QueResult.ForeachMatchElem(func(oneElem *MatchElem, elemIdx int) {
-		queMgr.LeaveQueue(oneElem.ElemKey, true)
+	result.ForeachMatchElem(func(oneElem *MatchElem, elemIdx int) {
+		queMgr.LeaveQueue(oneElem.ElemKey, LeaveReasonSuccess)
 	})
 }
 
@@ -128,14 +243,17 @@ type SupplyJobBase struct {
 
 	QueKey    MatchQueueKey
 	QueMap    MapInfo
-	QueElems  []*MatchElem
+	QueElems  []*MatchElem // 切片每个job一份可以排序; elem是队列elem的只读副本, 多个job共用, 不能修改
 	QueResult *MatchResult
+
+	Ctx     context.Context // 超时或取消后Err()不为nil, DoThreadSupply应尽早退出
+	seq     uint64          // job序号, 用于丢弃超时的结果
+	jobCost time.Duration   // DoThreadSupply耗时
 }
 
 func newSupplyJob(ach ISupplyAchieve) *SupplyJobBase {
 	return &SupplyJobBase{
 		ISupplyAchieve: ach,
-		QueElems:       make([]*MatchElem, 0),
 		QueResult:      NewMatchResult(),
 	}
 }
@@ -151,23 +269,25 @@ func (sj *SupplyJobBase) init(mgrGetter xmodule.DModuleGetter, queKey MatchQueue
 	sj.SupInfo = supInfo
 	sj.QueKey = queKey
 	sj.QueMap = mapInfo
-	sj.QueElems = append(sj.QueElems, matchQue.copyCanMatchElems()...)
+	sj.QueElems = copyElemView(matchQue.canMatchView())
 	return len(sj.QueElems) > 0
 }
 
 func (sj *SupplyJobBase) DoJob() job.Done {
+	startTime := time.Now()
 	sj.DoThreadSupply(sj)
+	sj.jobCost = time.Since(startTime)
 	return sj
 }
 
 func (sj *SupplyJobBase) DoReturn() {
 	queMgr := sj.getMatchQueueMgr()
-	matchQue := queMgr.findMatchQueue(sj.QueKey)
-	if matchQue == nil {
-		xlog.Errorf("<queue_match> supply return no queueKey=%v", sj.QueKey)
+	// 超时/取消/队列已删除, 丢弃结果
+	if !queMgr.acceptJobReturn(sj.QueKey, sj.Ctx, sj.seq) {
 		return
 	}
-	matchQue.inMatch = false
+	queStat := queMgr.getQueueStat(sj.QueKey)
+	queStat.counter.jobCost.observe(sj.jobCost.Seconds())
 	groupLen := len(sj.QueResult.Groups)
 	// 增补结果为空, 说明增补失败
 	if groupLen <= 0 {
@@ -177,18 +297,22 @@ func (sj *SupplyJobBase) DoReturn() {
 	// 保底检查一下这些匹配元素是否存在. 避免幽灵匹配
 	allElemExist := true
 	sj.QueResult.ForeachMatchElem(func(oneElem *MatchElem, elemIdx int) {
-		if findElem, _ := queMgr.FindMatchElem(oneElem.ElemKey); findElem == nil {
+		if findElem, _ := queMgr.findMatchElem(oneElem.ElemKey); findElem == nil {
 			allElemExist = false
 			return
 		}
 	})
 	if !allElemExist {
+		queStat.counter.ghostReject++
 		return
 	}
+	queMgr.liveResultElems(sj.QueResult)
 	allok := queMgr.successDo.SupplySuccess(sj.QueResult, sj.SupInfo)
 	if !allok {
+		queStat.counter.successRefuse++
 		return
 	}
+	queMgr.recordMatched(sj.QueKey, sj.QueResult, true)
 	// log
 	xlog.InfoF("<queue_match> supply success queKey=%v, result:", sj.QueKey)
 	sj.QueResult.ForeachMatchElem(func(oneElem *MatchElem, elemIdx int) {
@@ -196,6 +320,6 @@ func (sj *SupplyJobBase) DoReturn() {
 	})
 	// 离开匹配队列
 	sj.QueResult.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
-		queMgr.LeaveQueue(oneElem.ElemKey, true)
+		queMgr.LeaveQueue(oneElem.ElemKey, LeaveReasonSuccess)
 	})
 }
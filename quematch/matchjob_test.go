@@ -0,0 +1,231 @@
+package quematch
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+// 原地倒序排QueElems, 不出结果
+type sortInPlaceAchieve struct {
+	calls *int
+}
+
+func (s *sortInPlaceAchieve) DoThreadMatch(base *MatchJobBase) {
+	*s.calls++
+	sort.Slice(base.QueElems, func(i, j int) bool {
+		return base.QueElems[i].ElemKey.ElemID > base.QueElems[j].ElemKey.ElemID
+	})
+}
+
+func (s *sortInPlaceAchieve) CreateNewSelf() IMatchAchieve {
+	return s
+}
+
+func testElemKey(id uint64) MatchElemKey {
+	return MatchElemKey{ElemType: MatchElemPerson, ElemID: id}
+}
+
+// 业务通过UpdateMatchElem修改的数据下次匹配能看到, 成局时回调的是业务自己的elem
+func TestUpdateMatchElemSeen(t *testing.T) {
+	collOK := &testCollOK{}
+	coll := NewSimDataCollector(collOK, testStart, 100*time.Millisecond)
+	if coll == nil {
+		t.Fatal("NewSimDataCollector failed")
+	}
+	defer coll.EndSimulation()
+	coll.RegisterMatchAchieve(MatchStrategyScore, &ScoreMatchAchieve{Expand: &ScoreExpandCfg{BaseRange: 100}})
+	coll.InitClientMapInfo(ClientKey{ServerID: 1}, MapInfo{MapID: testMapID, MatchTotalNeed: 2})
+	elems := []*MatchElem{newTestElem(1, 1000), newTestElem(2, 2000)}
+	coll.ReplayTrace(testTrace(MatchStrategyScore, 0, elems...), 2*time.Second)
+	if len(collOK.results) != 0 {
+		t.Fatalf("matched before update: %v", resultElemIDs(collOK.results[0]))
+	}
+	updated := coll.GetMatchMgr().UpdateMatchElem(testElemKey(2), func(elem *MatchElem) {
+		if elem != elems[1] {
+			t.Fatal("UpdateMatchElem did not pass the queued elem")
+		}
+		elem.ElemData.(*ScoreMatchElemData).Gamers[0].Score = 1050
+	})
+	if !updated {
+		t.Fatal("UpdateMatchElem failed")
+	}
+	coll.ReplayTrace(nil, 2*time.Second)
+	if len(collOK.results) != 1 {
+		t.Fatalf("got %d results after update, want 1", len(collOK.results))
+	}
+	result := collOK.results[0]
+	checkResultElems(t, result, 1, 2)
+	for _, oneElem := range result.Groups {
+		if oneElem != elems[oneElem.ElemKey.ElemID-1] {
+			t.Fatalf("result elem %d is not the queued elem", oneElem.ElemKey.ElemID)
+		}
+	}
+}
+
+// 查找不动视图; 修改只换掉该elem的副本, 视图不重建
+func TestUpdateMatchElemKeepsView(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyScore}
+	mqm.EnterWaitQueue(queKey, newTestElem(1, 1000))
+	mqm.EnterWaitQueue(queKey, newTestElem(2, 2000))
+	matchQue := mqm.findMatchQueue(queKey)
+	view := matchQue.canMatchView()
+	oldFrozen := view[0]
+	mqm.FindMatchElem(testElemKey(1))
+	if len(matchQue.elemView) != 2 || matchQue.elemView[0] != oldFrozen {
+		t.Fatal("FindMatchElem dropped the view")
+	}
+	mqm.UpdateMatchElem(testElemKey(1), func(elem *MatchElem) {
+		elem.ElemData.(*ScoreMatchElemData).Gamers[0].Score = 1500
+	})
+	newView := matchQue.canMatchView()
+	if &newView[0] != &view[0] {
+		t.Fatal("view rebuilt after update")
+	}
+	if newView[0] == oldFrozen || newView[0].ElemData.(*ScoreMatchElemData).Gamers[0].Score != 1500 {
+		t.Fatal("updated elem copy not replaced")
+	}
+	if oldFrozen.ElemData.(*ScoreMatchElemData).Gamers[0].Score != 1000 {
+		t.Fatal("old copy changed")
+	}
+	// 排序时间变了重建视图
+	mqm.UpdateMatchElem(testElemKey(2), func(elem *MatchElem) {
+		elem.StartTime = elem.StartTime.Add(-time.Hour)
+	})
+	if newView = matchQue.canMatchView(); newView[0].ElemKey.ElemID != 2 {
+		t.Fatalf("view order not refreshed: first=%d", newView[0].ElemKey.ElemID)
+	}
+	if mqm.UpdateMatchElem(testElemKey(3), func(*MatchElem) {}) {
+		t.Fatal("UpdateMatchElem found missing elem")
+	}
+}
+
+// 确认失败放回队列时重新生成副本
+func TestRestoreElemRefreshesFrozen(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyScore}
+	mqm.EnterWaitQueue(queKey, newTestElem(1, 1000))
+	matchQue := mqm.findMatchQueue(queKey)
+	matchQue.canMatchView()
+	held := mqm.holdElem(testElemKey(1))
+	held.elem.ElemData.(*ScoreMatchElemData).Gamers[0].Score = 1200
+	mqm.restoreElem(held)
+	view := matchQue.canMatchView()
+	if len(view) != 1 || view[0] == held.elem {
+		t.Fatal("view does not hold a frozen copy")
+	}
+	if score := view[0].ElemData.(*ScoreMatchElemData).Gamers[0].Score; score != 1200 {
+		t.Fatalf("frozen score=%d, want 1200", score)
+	}
+}
+
+// 策略原地排序QueElems不影响队列的视图
+func TestJobSortsOwnElemSlice(t *testing.T) {
+	calls := 0
+	var matchQue *matchQueue
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: testStrategyCustom}
+	trace := testTrace(testStrategyCustom, 0, newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0))
+	setup := func(coll *MatchDataCollector) {
+		coll.RegisterMatchAchieve(testStrategyCustom, &sortInPlaceAchieve{calls: &calls})
+		matchQue = coll.GetMatchMgr().getOrNewQueue(queKey)
+	}
+	simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 2*time.Second, setup)
+	if calls < 2 {
+		t.Fatalf("calls=%d, want at least 2", calls)
+	}
+	view := matchQue.canMatchView()
+	for i, oneElem := range view {
+		if oneElem.ElemKey.ElemID != uint64(i+1) {
+			t.Fatalf("view order changed by job: idx=%d, elem=%d", i, oneElem.ElemKey.ElemID)
+		}
+	}
+}
+
+// 生成匹配job输入: 每个elem深拷贝(旧做法)和复用视图只复制切片
+func BenchmarkJobElems(b *testing.B) {
+	coll, elems := newBenchQueue(b, benchQueueLen)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	matchQue := mqm.findMatchQueue(benchQueKey)
+	b.Run("clone", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			jobElems := make([]*MatchElem, 0, len(elems))
+			matchQue.matchElems.foreach(func(oneElem *MatchElem) bool {
+				jobElems = append(jobElems, oneElem.clone())
+				return true
+			})
+		}
+	})
+	b.Run("view", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			copyElemView(matchQue.canMatchView())
+		}
+	})
+	// 每次有一个elem被业务修改
+	b.Run("view-update", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mqm.UpdateMatchElem(elems[i%len(elems)].ElemKey, func(elem *MatchElem) {
+				elem.Priority = 0
+			})
+			copyElemView(matchQue.canMatchView())
+		}
+	})
+}
+
+// 一个job出多局, 每局分别选client并计入负载
+func TestMultipleMatchesPerJob(t *testing.T) {
+	// 返回的collector用完要EndSimulation
+	run := func(maxPerJob int32, capacity int32) (*testCollOK, *MatchDataCollector) {
+		collOK := &testCollOK{}
+		coll := NewSimDataCollector(collOK, testStart, 100*time.Millisecond)
+		if coll == nil {
+			t.Fatal("NewSimDataCollector failed")
+		}
+		mqm := coll.GetMatchMgr()
+		mqm.SetMatchBaseCfg(MatchBaseCfg{MaxMatchPerJob: maxPerJob})
+		mapInfo := MapInfo{MapID: testMapID, MatchTotalNeed: 2}
+		for serverID := uint32(1); serverID <= 2; serverID++ {
+			coll.InitClientMapInfo(ClientKey{ServerID: serverID}, mapInfo)
+			mqm.GetMatchClientInfo(ClientKey{ServerID: serverID}).MaxPlayerNum = capacity
+		}
+		trace := testTrace(MatchStrategyNormal, 0, newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0),
+			newTestElem(4, 0), newTestElem(5, 0), newTestElem(6, 0))
+		coll.ReplayTrace(trace, 1500*time.Millisecond)
+		return collOK, coll
+	}
+	// 一局一个job, 一轮只出一局
+	collOK, coll := run(1, 100)
+	coll.EndSimulation()
+	if len(collOK.results) != 1 {
+		t.Fatalf("got %d results with one match per job, want 1", len(collOK.results))
+	}
+	// 一个job三局
+	collOK, coll = run(3, 100)
+	coll.EndSimulation()
+	if len(collOK.results) != 3 {
+		t.Fatalf("got %d results with three matches per job, want 3", len(collOK.results))
+	}
+	// 每台client只放得下一局, 第二局换client, 第三局没有client放弃
+	collOK, coll = run(3, 3)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	if len(collOK.results) != 2 {
+		t.Fatalf("got %d results with two small clients, want 2", len(collOK.results))
+	}
+	checkResultElems(t, collOK.results[0], 1, 2)
+	checkResultElems(t, collOK.results[1], 3, 4)
+	for serverID := uint32(1); serverID <= 2; serverID++ {
+		if load := mqm.GetMatchClientInfo(ClientKey{ServerID: serverID}); load.hungry() > 1 {
+			t.Fatalf("client %d hungry=%d, want a match counted", serverID, load.hungry())
+		}
+	}
+	for _, id := range []uint64{5, 6} {
+		if findElem, _ := mqm.FindMatchElem(testElemKey(id)); findElem == nil {
+			t.Fatalf("elem %d left without a client", id)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package quematch
+
+import (
+	"context"
+	"github.com/qixi7/xengine_core/xlog"
+	"time"
+)
+
+/*
+	matchjobctl.go: 匹配job超时和取消
+	每个job带一个有截止时间的context, DoThreadMatch应定期检查base.Ctx.Err()尽早退出.
+	Run中检查超时: 超时的队列直接清掉inMatch, 之后返回的结果按序号对不上丢弃
+*/
+
+// 开始一个队列job, 返回job的context和序号. supInfo为增补请求, 超时后放回队列
+func (mqm *MatchQueueMgr) startQueueJob(matchQue *matchQueue, supInfo *SupplyInfo) (context.Context, uint64) {
+	mqm.jobSeq++
+	var ctx context.Context
+	if mqm.baseCfg.JobTimeoutMs > 0 {
+		timeout := time.Duration(mqm.baseCfg.JobTimeoutMs) * time.Millisecond
+		ctx, matchQue.jobCancel = context.WithTimeout(context.Background(), timeout)
+		matchQue.jobDeadline = time.Now().Add(timeout)
+	} else {
+		ctx, matchQue.jobCancel = context.WithCancel(context.Background())
+		matchQue.jobDeadline = time.Time{}
+	}
+	matchQue.inMatch = true
+	matchQue.jobSeq = mqm.jobSeq
+	matchQue.jobSupply = supInfo
+	return ctx, mqm.jobSeq
+}
+
+// job是否还有效
+func (mq *matchQueue) jobAlive(seq uint64) bool {
+	return mq.inMatch && mq.jobSeq == seq
+}
+
+// 结束当前job
+func (mq *matchQueue) endJob() {
+	if mq.jobCancel != nil {
+		mq.jobCancel()
+	}
+	mq.inMatch = false
+	mq.jobSeq = 0
+	mq.jobCancel = nil
+	mq.jobDeadline = time.Time{}
+	mq.jobSupply = nil
+}
+
+// 取消队列当前job, 增补请求放回队列
+func (mq *matchQueue) cancelJob() {
+	supInfo := mq.jobSupply
+	mq.endJob()
+	if supInfo != nil {
+		mq.addSupply(supInfo)
+	}
+}
+
+// 检查超时的job
+func (mqm *MatchQueueMgr) checkJobDeadline() {
+	now := time.Now()
+	for queKey, oneQue := range mqm.waitingQueue {
+		if !oneQue.inMatch || oneQue.jobDeadline.IsZero() || now.Before(oneQue.jobDeadline) {
+			continue
+		}
+		xlog.Errorf("<queue_match> match job timeout, queKey=%v, seq=%d", queKey, oneQue.jobSeq)
+		oneQue.cancelJob()
+		mqm.getQueueStat(queKey).counter.jobTimeout++
+	}
+}
+
+// 取消所有进行中的job
+func (mqm *MatchQueueMgr) cancelJobs() {
+	for _, oneQue := range mqm.waitingQueue {
+		if oneQue.inMatch {
+			oneQue.cancelJob()
+		}
+	}
+}
+
+// job返回时检查是否还有效, 无效时丢弃结果
+func (mqm *MatchQueueMgr) acceptJobReturn(queKey MatchQueueKey, ctx context.Context, seq uint64) bool {
+	matchQue := mqm.findMatchQueue(queKey)
+	if matchQue == nil || !matchQue.jobAlive(seq) {
+		xlog.InfoF("<queue_match> discard late job result, queKey=%v, seq=%d", queKey, seq)
+		if matchQue != nil {
+			mqm.getQueueStat(queKey).counter.jobLate++
+		}
+		return false
+	}
+	// 已经超时但还没被检查到, 同样丢弃
+	if ctx != nil && ctx.Err() != nil {
+		xlog.Errorf("<queue_match> match job timeout, queKey=%v, seq=%d", queKey, seq)
+		matchQue.cancelJob()
+		mqm.getQueueStat(queKey).counter.jobTimeout++
+		return false
+	}
+	matchQue.endJob()
+	return true
+}
+
+// 删除队列: 取消进行中的job, 只在该队列的elem离开匹配, 同时在其他队列的elem只从该队列删除.
+// 主队列被删的elem改用剩下的第一个队列做主队列
+func (mqm *MatchQueueMgr) RemoveQueue(queKey MatchQueueKey) bool {
+	matchQue := mqm.findMatchQueue(queKey)
+	if matchQue == nil {
+		return false
+	}
+	matchQue.endJob()
+	leaveKeys := make([]MatchElemKey, 0, matchQue.getElemLen())
+	ticketKeys := make([]MatchElemKey, 0)
+	matchQue.matchElems.foreach(func(oneElem *MatchElem) bool {
+		if mqm.elem2MatchQueue[oneElem.ElemKey] == queKey && len(mqm.elemTickets[oneElem.ElemKey]) <= 1 {
+			leaveKeys = append(leaveKeys, oneElem.ElemKey)
+		} else {
+			ticketKeys = append(ticketKeys, oneElem.ElemKey)
+		}
+		return true
+	})
+	for _, elemKey := range leaveKeys {
+		mqm.LeaveQueue(elemKey, LeaveReasonQueueRemove)
+	}
+	for _, elemKey := range ticketKeys {
+		matchQue.delMatch(elemKey)
+		if mqm.elem2MatchQueue[elemKey] == queKey {
+			for _, oneKey := range mqm.elemTickets[elemKey] {
+				if oneKey != queKey {
+					mqm.elem2MatchQueue[elemKey] = oneKey
+					xlog.InfoF("<queue_match> move main queue: elemKey=%v, from=%v, to=%v", elemKey, queKey, oneKey)
+					break
+				}
+			}
+		}
+		mqm.removeTicketKey(elemKey, queKey)
+	}
+	delete(mqm.waitingQueue, queKey)
+	delete(mqm.queMaxWait, queKey)
+	xlog.InfoF("<queue_match> remove queue: queKey=%v, leaveNum=%d", queKey, len(leaveKeys))
+	return true
+}
+
+// --------------------------- 匹配线程使用 ---------------------------
+
+// job是否已超时或被取消
+func (mj *MatchJobBase) Canceled() bool {
+	return mj.Ctx != nil && mj.Ctx.Err() != nil
+}
+
+// job是否已超时或被取消
+func (sj *SupplyJobBase) Canceled() bool {
+	return sj.Ctx != nil && sj.Ctx.Err() != nil
+}
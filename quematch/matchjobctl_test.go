@@ -0,0 +1,62 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+const testStrategyCustom = 100
+
+// 第一次匹配卡住直到超时, 之后直接把队列里的elem都凑成一局
+type blockOnceAchieve struct {
+	calls *int
+}
+
+func (b *blockOnceAchieve) DoThreadMatch(base *MatchJobBase) {
+	*b.calls++
+	if *b.calls == 1 {
+		select {
+		case <-base.Ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	base.QueResult.AddGroup(base.QueElems...)
+}
+
+func (b *blockOnceAchieve) CreateNewSelf() IMatchAchieve {
+	return b
+}
+
+func TestJobTimeoutDefaultOff(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	if mqm.baseCfg.JobTimeoutMs != 0 {
+		t.Fatalf("default JobTimeoutMs=%d, want 0", mqm.baseCfg.JobTimeoutMs)
+	}
+	matchQue := mqm.getOrNewQueue(MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal})
+	ctx, _ := mqm.startQueueJob(matchQue, nil)
+	if _, ok := ctx.Deadline(); ok || !matchQue.jobDeadline.IsZero() {
+		t.Fatal("job has deadline without JobTimeoutMs")
+	}
+}
+
+func TestJobTimeoutDiscardsResult(t *testing.T) {
+	calls := 0
+	var mqm *MatchQueueMgr
+	trace := testTrace(testStrategyCustom, 0, newTestElem(1, 0), newTestElem(2, 0))
+	setup := func(coll *MatchDataCollector) {
+		mqm = coll.GetMatchMgr()
+		mqm.SetMatchBaseCfg(MatchBaseCfg{JobTimeoutMs: 20})
+		coll.RegisterMatchAchieve(testStrategyCustom, &blockOnceAchieve{calls: &calls})
+	}
+	results := simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 3*time.Second, setup)
+	if calls < 2 {
+		t.Fatalf("calls=%d, want retry after timeout", calls)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1 after timeout retry", len(results))
+	}
+	queStat := mqm.getQueueStat(MatchQueueKey{MapID: testMapID, MatchStrategy: testStrategyCustom})
+	if queStat.counter.jobTimeout != 1 {
+		t.Fatalf("jobTimeout=%d, want 1", queStat.counter.jobTimeout)
+	}
+}
@@ -0,0 +1,55 @@
+package quematch
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+	matchlane.go: 优先通道
+	VIP、对局异常后重新排队的玩家等可以插队: 当作多等了一段时间, 排序时更靠前.
+	提前秒数有上限, 普通玩家多等一会就会排到前面, 不会一直被插队
+*/
+
+// 优先通道
+type MatchLane uint32
+
+const (
+	MatchLaneNormal    MatchLane = iota // 普通
+	MatchLaneVIP                        // VIP
+	MatchLaneReturning                  // 对局异常后重新排队
+)
+
+// 通道配置
+type MatchLaneCfg struct {
+	BoostSecond int64 // 当作多等了多少秒
+}
+
+// 设置通道配置
+func (mqm *MatchQueueMgr) SetLaneCfg(lane MatchLane, cfg MatchLaneCfg) {
+	mqm.laneCfg[lane] = cfg
+}
+
+// 进队时计算提前秒数: 通道提前秒数 + 单元优先级, 不超过MaxBoostSecond
+func (mqm *MatchQueueMgr) applyBoost(elem *MatchElem) {
+	boost := mqm.laneCfg[elem.Lane].BoostSecond + int64(elem.Priority)
+	if boost < 0 {
+		boost = 0
+	}
+	if mqm.baseCfg.MaxBoostSecond > 0 && boost > mqm.baseCfg.MaxBoostSecond {
+		boost = mqm.baseCfg.MaxBoostSecond
+	}
+	elem.boostSecond = boost
+}
+
+// 排序用的进队时间, 提前秒数越多越靠前
+func (me *MatchElem) OrderTime() time.Time {
+	return me.StartTime.Add(-time.Duration(me.boostSecond) * time.Second)
+}
+
+// 按排序时间从早到晚排序, 相同时保持原顺序
+func SortElemByOrder(elems []*MatchElem) {
+	sort.SliceStable(elems, func(i, j int) bool {
+		return elems[i].OrderTime().Before(elems[j].OrderTime())
+	})
+}
@@ -0,0 +1,45 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLaneBoostCap(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	mqm.SetLaneCfg(MatchLaneVIP, MatchLaneCfg{BoostSecond: 1000})
+	elem := newTestElem(1, 1000)
+	elem.Lane = MatchLaneVIP
+	mqm.applyBoost(elem)
+	if elem.boostSecond != 300 {
+		t.Fatalf("boost=%d, want default cap 300", elem.boostSecond)
+	}
+	// 0不修改
+	mqm.SetMatchBaseCfg(MatchBaseCfg{MaxBoostSecond: 0})
+	mqm.applyBoost(elem)
+	if elem.boostSecond != 300 {
+		t.Fatalf("boost=%d, want 300 after zero cfg", elem.boostSecond)
+	}
+	mqm.SetMatchBaseCfg(MatchBaseCfg{MaxBoostSecond: -1})
+	mqm.applyBoost(elem)
+	if elem.boostSecond != 1000 {
+		t.Fatalf("boost=%d, want unlimited 1000", elem.boostSecond)
+	}
+}
+
+func TestLaneVIPMatchedFirst(t *testing.T) {
+	trace := testTraceInOrder(MatchStrategyNormal,
+		newTestElem(1, 0),
+		newTestElem(2, 0),
+		newTestElem(3, 0),
+	)
+	trace[2].Elem.Lane = MatchLaneVIP
+	setup := func(coll *MatchDataCollector) {
+		coll.GetMatchMgr().SetLaneCfg(MatchLaneVIP, MatchLaneCfg{BoostSecond: 60})
+	}
+	results := simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 2*time.Second, setup)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkResultElems(t, results[0], 1, 3)
+}
@@ -0,0 +1,134 @@
+package quematch
+
+/*
+	matchlatency.go: 区域延迟匹配
+	client服务器有所在区域, 玩家上报到各区域的延迟. 只有所有人到该区域延迟都能接受才能成局,
+	允许的延迟随等待时间放宽
+*/
+
+// 允许延迟扩展配置, 单位毫秒. 曲线和分数扩展相同
+type LatencyExpandCfg struct {
+	Curve      ExpandCurve // 曲线类型
+	BaseMs     int32       // 初始允许延迟
+	StepMs     int32       // 线性/阶梯每步增加的延迟
+	StepSecond int64       // 每步秒数, <=0当1秒处理
+	ExpFactor  float64     // 指数曲线倍率
+	MaxMs      int32       // 允许延迟上限, <=0表示不限
+}
+
+// 等待waitSecond秒后允许的延迟
+func (cfg *LatencyExpandCfg) Limit(waitSecond int64) int32 {
+	curve := ScoreExpandCfg{
+		Curve:      cfg.Curve,
+		BaseRange:  cfg.BaseMs,
+		StepRange:  cfg.StepMs,
+		StepSecond: cfg.StepSecond,
+		ExpFactor:  cfg.ExpFactor,
+		MaxRange:   cfg.MaxMs,
+	}
+	return curve.Range(waitSecond)
+}
+
+// 某个匹配元素当前允许的延迟
+func (cfg *LatencyExpandCfg) ElemLimit(elem *MatchElem) int32 {
+	return cfg.Limit(elem.WaitSecond())
+}
+
+// 设置允许延迟随等待时间的扩展配置, nil表示不限制延迟
+func (mqm *MatchQueueMgr) SetLatencyLimit(cfg *LatencyExpandCfg) {
+	if cfg == nil {
+		mqm.latencyLimit = nil
+		return
+	}
+	limit := *cfg
+	mqm.latencyLimit = &limit
+}
+
+// elem到region的延迟是否可以接受
+func latencyAccept(limit *LatencyExpandCfg, elem *MatchElem, region uint32) bool {
+	if limit == nil {
+		return true
+	}
+	data, ok := elem.ElemData.(*ScoreMatchElemData)
+	if !ok {
+		return true
+	}
+	latency, ok := data.maxLatency(region)
+	if !ok {
+		return false
+	}
+	return latency <= limit.ElemLimit(elem)
+}
+
+// 为队列挑一个client服务器: 负载足够, 且等待最久的elem到该区域延迟可以接受.
+// 只看队首等待最久的elem的延迟, 其他elem在job里按该区域过滤, 成局后pickResultClient再检查所有人.
+// 没有满足延迟的就用第一个负载足够的, 避免队列一直匹配不了
+func (mqm *MatchQueueMgr) pickQueueClient(item *queueSchedItem, hungryList []ClientCandidate) *matchClient {
+	var firstHungry *matchClient
+	var oldest *MatchElem
+	if matchQue := mqm.findMatchQueue(item.queKey); matchQue != nil {
+		oldest = matchQue.matchElems.front()
+	}
+	for _, oneCand := range hungryList {
+		oneHungry, ok := mqm.matchClientInfo[oneCand.Key]
+		if !ok || !oneHungry.canMatch() || !oneHungry.load.canHold(item.mapInfo.MatchTotalNeed) {
+			continue
+		}
+		if mqm.latencyLimit == nil || oldest == nil ||
+			latencyAccept(mqm.latencyLimit, oldest, oneHungry.load.Region) {
+			return oneHungry
+		}
+		if firstHungry == nil {
+			firstHungry = oneHungry
+		}
+	}
+	return firstHungry
+}
+
+// 为一个匹配结果挑client服务器: 按选择策略顺序, 负载足够且所有elem到该区域延迟都可以接受
+func (mqm *MatchQueueMgr) pickResultClient(result *MatchResult, mapInfo *MapInfo) *matchClient {
+	hungryList := make([]ClientCandidate, 0, len(mqm.matchClientInfo))
+	for _, cliInfo := range mqm.matchClientInfo {
+		if cliInfo.canMatch() && cliInfo.load.canHold(mapInfo.MatchTotalNeed) {
+			hungryList = append(hungryList, ClientCandidate{Key: cliInfo.key, Load: cliInfo.load})
+		}
+	}
+	sortCandidateByKey(hungryList)
+	for _, oneCand := range mqm.clientSelector.SelectClients(hungryList) {
+		oneClient, ok := mqm.matchClientInfo[oneCand.Key]
+		if !ok {
+			continue
+		}
+		allAccept := true
+		result.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+			if !latencyAccept(mqm.latencyLimit, oneElem, oneClient.load.Region) {
+				allAccept = false
+			}
+		})
+		if allAccept {
+			return oneClient
+		}
+	}
+	return nil
+}
+
+// --------------------------- 匹配线程使用 ---------------------------
+
+// elem到本次匹配服务器所在区域的延迟是否可以接受
+func (mj *MatchJobBase) LatencyAccept(elem *MatchElem) bool {
+	return latencyAccept(mj.LatencyLimit, elem, mj.Region)
+}
+
+// 去掉延迟不能接受的elem
+func (mj *MatchJobBase) filterLatency() {
+	if mj.LatencyLimit == nil {
+		return
+	}
+	elems := mj.QueElems[:0]
+	for _, oneElem := range mj.QueElems {
+		if mj.LatencyAccept(oneElem) {
+			elems = append(elems, oneElem)
+		}
+	}
+	mj.QueElems = elems
+}
@@ -0,0 +1,49 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 新建elem, 到区域0的延迟为latency毫秒
+func newTestLatencyElem(id uint64, latency int32) *MatchElem {
+	elem := newTestElem(id, 0)
+	elem.ElemData.(*ScoreMatchElemData).Gamers[0].Latency = map[uint32]int32{0: latency}
+	return elem
+}
+
+func TestLatencyLimitMs(t *testing.T) {
+	cfg := &LatencyExpandCfg{Curve: ExpandCurveLinear, BaseMs: 50, StepMs: 50, StepSecond: 1, MaxMs: 200}
+	for _, c := range []struct {
+		wait int64
+		want int32
+	}{{0, 50}, {1, 100}, {2, 150}, {10, 200}} {
+		if got := cfg.Limit(c.wait); got != c.want {
+			t.Fatalf("Limit(%d)=%d, want %d", c.wait, got, c.want)
+		}
+	}
+}
+
+// 延迟高的要等允许延迟放宽才能成局
+func TestLatencyLimitWaitsForExpand(t *testing.T) {
+	trace := testTrace(MatchStrategyNormal, 0,
+		newTestLatencyElem(1, 40),
+		newTestLatencyElem(2, 120),
+		newTestLatencyElem(4, 130),
+	)
+	trace = append(trace, testTrace(MatchStrategyNormal, 500*time.Millisecond, newTestLatencyElem(3, 45))...)
+	setup := func(coll *MatchDataCollector) {
+		coll.GetMatchMgr().SetLatencyLimit(&LatencyExpandCfg{
+			Curve:      ExpandCurveLinear,
+			BaseMs:     50,
+			StepMs:     50,
+			StepSecond: 1,
+		})
+	}
+	results := simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 4*time.Second, setup)
+	if len(results) != 2 {
+		t.Fatalf("results=%d, want 2", len(results))
+	}
+	checkResultElems(t, results[0], 1, 3)
+	checkResultElems(t, results[1], 2, 4)
+}
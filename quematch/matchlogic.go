@@ -11,7 +11,9 @@ type IMatchSuccess interface {
 }
 
 type MatchResult struct {
-	Groups []*MatchElem
+	Groups     []*MatchElem
+	Sides      []*MatchSide         // 分边结果, 不分边时为空
+	RoleAssign map[uint64]MatchRole // gamerID -> 分配到的职业, 职业匹配时使用
 }
 
 func (mr *MatchResult) ForeachMatchElem(runFunc func(elem *MatchElem, elemIdx int)) {
@@ -33,41 +35,28 @@ func NewMatchResult() *MatchResult {
 // 匹配一次
 func tryMatchOnce(mqm *MatchQueueMgr) {
 	// 获取所有能匹配的client服务器
-	hungryList := make([]*matchClient, 0, len(mqm.matchClientInfo))
+	hungryList := make([]ClientCandidate, 0, len(mqm.matchClientInfo))
 	for _, cliInfo := range mqm.matchClientInfo {
 		if cliInfo.canMatch() {
-			hungryList = append(hungryList, cliInfo)
+			hungryList = append(hungryList, ClientCandidate{Key: cliInfo.key, Load: cliInfo.load})
 		}
 	}
 	if len(hungryList) <= 0 {
 		return
 	}
+	// 按选择策略排序
+	sortCandidateByKey(hungryList)
+	hungryList = mqm.clientSelector.SelectClients(hungryList)
 
-	// 逐个取出满足条件的client服务器和mapInfo进行匹配
+	// 按调度优先级逐个队列匹配, 每个队列分给选择策略中第一个负载足够(且延迟合适)的client服务器
 	matchedQue := make(map[MatchQueueKey]interface{}) // 已匹配过的队列, 用于检验busy
-	for _, oneHungry := range hungryList {
-		// 找出一个可以匹配的map
-		var canMatchMap *MapInfo = nil
-		for _, aMap := range mqm.mapsInfo {
-			// 剔除负载不足的mapInfo
-			if oneHungry.load.hungry() > aMap.MatchTotalNeed {
-				canMatchMap = &aMap
-				break
-			}
-		}
-		if canMatchMap == nil {
+	for _, oneItem := range mqm.scheduleQueues() {
+		oneHungry := mqm.pickQueueClient(&oneItem, hungryList)
+		if oneHungry == nil {
 			continue
 		}
-		// 获取所有该地图的待匹配队列
-		queKeys := mqm.getMatchQueueKeyByMapID(canMatchMap.MapID)
-		for i := 0; i < len(queKeys); i++ {
-			// 不满足负载了
-			if oneHungry.load.hungry() <= canMatchMap.MatchTotalNeed {
-				break
-			}
-			if tryMatchOnceQueue(mqm, queKeys[i], oneHungry.key, canMatchMap, matchedQue) {
-				mqm.matchClientInfo[oneHungry.key].load.CurPlayerNum += canMatchMap.MatchTotalNeed
-			}
+		if tryMatchOnceQueue(mqm, oneItem.queKey, oneHungry.key, &oneItem.mapInfo, matchedQue) {
+			oneHungry.load.CurPlayerNum += oneItem.mapInfo.MatchTotalNeed
 		}
 	}
 }
@@ -102,8 +91,8 @@ func tryMatchOnceQueue(mqm *MatchQueueMgr, queKey MatchQueueKey, cliKey ClientKe
 			return false
 		}
 		matchedQue[queKey] = nil // 占位
-		matchQue.inMatch = true
-		mqm.getJobController().PostJob(supplyJob)
+		supplyJob.Ctx, supplyJob.seq = mqm.startQueueJob(matchQue, supplyInfo)
+		mqm.postJob(supplyJob)
 		return true
 	}
 	matchAchieve := newMatchAchieve(queKey.MatchStrategy, mqm)
@@ -116,7 +105,7 @@ func tryMatchOnceQueue(mqm *MatchQueueMgr, queKey MatchQueueKey, cliKey ClientKe
 		return false
 	}
 	matchedQue[queKey] = nil // 占位
-	matchQue.inMatch = true
-	mqm.getJobController().PostJob(matchJob)
+	matchJob.Ctx, matchJob.seq = mqm.startQueueJob(matchQue, nil)
+	mqm.postJob(matchJob)
 	return true
 }
@@ -1,9 +1,11 @@
 package quematch
 
 type MapInfo struct {
-	MapID          uint32 // ID
-	MatchTotalNeed int32  // 匹配需求总人数
-	MatchSingleMax int32  // 单组需要人数
+	MapID          uint32          // ID
+	MatchTotalNeed int32           // 匹配需求总人数
+	MatchSingleMax int32           // 单组需要人数
+	RoleSlots      []MatchRoleSlot // 单组职业模板, 职业匹配时使用
+	Weight         int32           // 调度权重, <=0按1处理
 }
 
 // ClientKey...
@@ -13,8 +15,9 @@ type ClientKey struct {
 
 // ClientInfo...
 type ClientInfo struct {
-	CurPlayerNum int32 // 当前多少人
-	MaxPlayerNum int32 // 最大多少人
+	CurPlayerNum int32  // 当前多少人
+	MaxPlayerNum int32  // 最大多少人
+	Region       uint32 // 所在区域
 }
 
 // 获取还能承载多少人
@@ -22,6 +25,11 @@ func (c *ClientInfo) hungry() int32 {
 	return c.MaxPlayerNum - c.CurPlayerNum
 }
 
+// 能否再分配一局need人, 空位要多于need
+func (c *ClientInfo) canHold(need int32) bool {
+	return c.hungry() > need
+}
+
 // 匹配client服务器
 type matchClient struct {
 	key    ClientKey
@@ -0,0 +1,115 @@
+package quematch
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/qixi7/xengine_core/xmetric"
+	"math"
+	"strconv"
+)
+
+/*
+	matchmetric.go: 单个队列的性能统计. 计数器和直方图在主线程累计, 由Metric.Pull拷贝后Push.
+	xmetric.Gather只支持gauge和counter, 直方图拆成_bucket/_sum/_count三组counter推送, 见matchHistogram.push
+*/
+
+// 推送指标, *xmetric.Gather实现
+type metricPusher interface {
+	PushGaugeMetric(ch chan<- prometheus.Metric, name string, value float64, labels []string, labelValues ...string)
+	PushCounterMetric(ch chan<- prometheus.Metric, name string, value float64, labels []string, labelValues ...string)
+}
+
+var _ metricPusher = (*xmetric.Gather)(nil)
+
+// 等待时长直方图桶(秒)
+var matchWaitBuckets = []float64{1, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// job耗时直方图桶(秒)
+var matchJobBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// 直方图
+type matchHistogram struct {
+	buckets []float64 // 桶上界
+	counts  []uint64  // 每个桶的计数(不累加)
+	sum     float64
+	count   uint64
+}
+
+func newMatchHistogram(buckets []float64) matchHistogram {
+	return matchHistogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (mh *matchHistogram) observe(value float64) {
+	for i, upper := range mh.buckets {
+		if value <= upper {
+			mh.counts[i]++
+			break
+		}
+	}
+	mh.sum += value
+	mh.count++
+}
+
+func (mh *matchHistogram) clone() matchHistogram {
+	cloneHist := *mh
+	cloneHist.counts = make([]uint64, len(mh.counts))
+	copy(cloneHist.counts, mh.counts)
+	return cloneHist
+}
+
+// 按prometheus histogram的命名和标签推送: name_bucket{le}(累加), name_sum, name_count.
+// 限制: 三组都是独立的counter, /metrics中TYPE是counter不是histogram. histogram_quantile等按名字和le标签
+// 计算的PromQL可以直接用, 依赖TYPE识别直方图的工具不认. xmetric.Gather支持直方图后再改成一个histogram
+func (mh *matchHistogram) push(gather metricPusher, ch chan<- prometheus.Metric, name string,
+	labels []string, labelValues ...string) {
+	bucketLabels := append(append([]string{}, labels...), "le")
+	var cumulative uint64
+	for i, upper := range mh.buckets {
+		cumulative += mh.counts[i]
+		bucketValues := append(append([]string{}, labelValues...), strconv.FormatFloat(upper, 'f', -1, 64))
+		gather.PushCounterMetric(ch, name+"_bucket", float64(cumulative), bucketLabels, bucketValues...)
+	}
+	infValues := append(append([]string{}, labelValues...), strconv.FormatFloat(math.Inf(1), 'f', -1, 64))
+	gather.PushCounterMetric(ch, name+"_bucket", float64(mh.count), bucketLabels, infValues...)
+	gather.PushCounterMetric(ch, name+"_sum", mh.sum, labels, labelValues...)
+	gather.PushCounterMetric(ch, name+"_count", float64(mh.count), labels, labelValues...)
+}
+
+// 单个队列的计数
+type queueCounter struct {
+	matchNum      uint64         // 匹配成功次数
+	supplyNum     uint64         // 增补成功次数
+	ghostReject   uint64         // 幽灵匹配丢弃次数
+	successRefuse uint64         // MatchSuccess/SupplySuccess返回false次数
+	jobTimeout    uint64         // job超时次数
+	jobLate       uint64         // 超时后才返回被丢弃的job次数
+	matchRepair   uint64         // 匹配结果缺人后修补成功次数
+	matchWait     matchHistogram // 匹配成功的elem等待时长
+	queueWait     matchHistogram // 所有离开队列的elem在队列中时长
+	jobCost       matchHistogram // DoThreadMatch/DoThreadSupply耗时
+}
+
+func newQueueCounter() queueCounter {
+	return queueCounter{
+		matchWait: newMatchHistogram(matchWaitBuckets),
+		queueWait: newMatchHistogram(matchWaitBuckets),
+		jobCost:   newMatchHistogram(matchJobBuckets),
+	}
+}
+
+func (qc *queueCounter) clone() queueCounter {
+	cloneCounter := *qc
+	cloneCounter.matchWait = qc.matchWait.clone()
+	cloneCounter.queueWait = qc.queueWait.clone()
+	cloneCounter.jobCost = qc.jobCost.clone()
+	return cloneCounter
+}
+
+// 单个队列的性能数据
+type queueMetric struct {
+	matchLen  int // 匹配中elem数
+	supplyLen int // 增补请求数
+	counter   queueCounter
+}
@@ -0,0 +1,84 @@
+package quematch
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 记录推送的指标, key为name{label=value,...}
+type testMetricPusher struct {
+	values map[string]float64
+}
+
+func testMetricKey(name string, labels []string, labelValues ...string) string {
+	pairs := make([]string, 0, len(labels))
+	for i, label := range labels {
+		pairs = append(pairs, label+"="+labelValues[i])
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (p *testMetricPusher) PushGaugeMetric(_ chan<- prometheus.Metric, name string, value float64,
+	labels []string, labelValues ...string) {
+	p.values[testMetricKey(name, labels, labelValues...)] = value
+}
+
+func (p *testMetricPusher) PushCounterMetric(_ chan<- prometheus.Metric, name string, value float64,
+	labels []string, labelValues ...string) {
+	p.values[testMetricKey(name, labels, labelValues...)] = value
+}
+
+// 推送的计数、队列长度和直方图的值和标签
+func TestMetricPush(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	clock := NewVirtualClock(testStart)
+	mqm.SetMatchClock(clock)
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0)}
+	for _, oneElem := range elems {
+		mqm.EnterWaitQueue(queKey, oneElem)
+	}
+	clock.Advance(7 * time.Second)
+	result := &MatchResult{}
+	result.AddGroup(elems[:2]...)
+	mqm.recordMatched(queKey, result, false)
+	clock.Advance(100 * time.Second)
+	mqm.LeaveQueue(testElemKey(3), LeaveReasonCancel)
+
+	m := &Metric{}
+	m.Pull(mqm)
+	pusher := &testMetricPusher{values: make(map[string]float64)}
+	m.push(pusher, nil)
+	mapID := strconv.Itoa(testMapID)
+	strategy := strconv.Itoa(int(MatchStrategyNormal))
+	queLabel := "map=" + mapID + ",strategy=" + strategy
+	wants := map[string]float64{
+		"match_totalmatch_len{}":                                       2,
+		"match_queue_len{" + queLabel + "}":                            2,
+		"match_formed_total{" + queLabel + "}":                         1,
+		"match_supply_total{" + queLabel + "}":                         0,
+		"match_time_to_match_seconds_bucket{" + queLabel + ",le=5}":    0,
+		"match_time_to_match_seconds_bucket{" + queLabel + ",le=10}":   2,
+		"match_time_to_match_seconds_bucket{" + queLabel + ",le=600}":  2,
+		"match_time_to_match_seconds_bucket{" + queLabel + ",le=+Inf}": 2,
+		"match_time_to_match_seconds_sum{" + queLabel + "}":            14,
+		"match_time_to_match_seconds_count{" + queLabel + "}":          2,
+		"match_time_in_queue_seconds_bucket{" + queLabel + ",le=60}":   0,
+		"match_time_in_queue_seconds_bucket{" + queLabel + ",le=120}":  1,
+		"match_time_in_queue_seconds_bucket{" + queLabel + ",le=+Inf}": 1,
+		"match_time_in_queue_seconds_sum{" + queLabel + "}":            107,
+		"match_job_duration_seconds_count{" + queLabel + "}":           0,
+	}
+	for key, want := range wants {
+		got, ok := pusher.values[key]
+		if !ok {
+			t.Fatalf("metric %s not pushed", key)
+		}
+		if got != want {
+			t.Fatalf("metric %s=%v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xlog"
+	"github.com/qixi7/xengine_pub/algorithm/subsetproblem"
+)
+
+/*
+	matchnormal.go: 内置常规匹配算法
+	不看分数, 把不同人数的单元按先来先匹配的顺序恰好装满每一组, 组队单元不拆开
+*/
+
+// 常规匹配算法
+type NormalMatchAchieve struct {
+}
+
+// 从pool中挑出人数恰好为need的单元, 用到的最晚单元尽量早(越早进队的越优先). 返回pool下标
+// 子集和第一次凑出need人时就是最短的前缀, O(len(pool)*need)
+func packOldestFirst(pool []*MatchElem, need int32) []int {
+	if need <= 0 {
+		return []int{}
+	}
+	set := make([]int, len(pool))
+	for k := 0; k < len(pool); k++ {
+		set[k] = pool[k].ElemData.GamerNum()
+	}
+	return subsetproblem.GetSubset(set, int(need))
+}
+
+// 按人数计数, 不看顺序能否凑出need人. 用于提前排除怎么都凑不满的情况(比如全是双人队凑5人)
+func gamerNumReachable(numCount map[int32]int, need int32) bool {
+	reach := make([]bool, need+1)
+	reach[0] = true
+	for num, count := range numCount {
+		if num <= 0 || num > need {
+			continue
+		}
+		// 有界背包: 每种人数最多用count个
+		for ; count > 0; count-- {
+			changed := false
+			for sum := need; sum >= num; sum-- {
+				if !reach[sum] && reach[sum-num] {
+					reach[sum] = true
+					changed = true
+				}
+			}
+			if reach[need] {
+				return true
+			}
+			if !changed {
+				break
+			}
+		}
+	}
+	return reach[need]
+}
+
+// 选中的elems之间是否都能匹配到一起
+func pickedCanPair(base *MatchJobBase, elems []*MatchElem, picked []int) bool {
+	if base.Constraint == nil {
+		return true
+	}
+	for i := 0; i < len(picked); i++ {
+		for j := i + 1; j < len(picked); j++ {
+			if !base.CanPairElem(elems[picked[i]], elems[picked[j]]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 凑满一组, chosen为之前几组已选中的. 返回选中的elems下标
+func (nma *NormalMatchAchieve) packSide(base *MatchJobBase, elems []*MatchElem, used []bool,
+	chosen []*MatchElem, sideSize int32) []int {
+	// 先看剩下的人数能不能凑满, 凑不满就不用逐个锚点搜索了
+	numCount := make(map[int32]int)
+	var totalNum int32
+	for i := 0; i < len(elems); i++ {
+		num := int32(elems[i].ElemData.GamerNum())
+		if used[i] || num > sideSize {
+			continue
+		}
+		numCount[num]++
+		totalNum += num
+	}
+	if totalNum < sideSize || !gamerNumReachable(numCount, sideSize) {
+		return nil
+	}
+	for anchorIdx := 0; anchorIdx < len(elems); anchorIdx++ {
+		if base.Canceled() {
+			return nil
+		}
+		anchorNum := int32(elems[anchorIdx].ElemData.GamerNum())
+		if used[anchorIdx] || anchorNum > sideSize || !base.CanJoin(elems[anchorIdx], chosen) {
+			continue
+		}
+		// 以最早进队的单元为锚点, 从后面的单元中补齐
+		pool := make([]*MatchElem, 0, len(elems))
+		poolIdx := make([]int, 0, len(elems))
+		for i := anchorIdx + 1; i < len(elems); i++ {
+			if used[i] || int32(elems[i].ElemData.GamerNum()) > sideSize-anchorNum {
+				continue
+			}
+			if !base.CanPairElem(elems[anchorIdx], elems[i]) || !base.CanJoin(elems[i], chosen) {
+				continue
+			}
+			pool = append(pool, elems[i])
+			poolIdx = append(poolIdx, i)
+		}
+		subset := packOldestFirst(pool, sideSize-anchorNum)
+		if subset == nil {
+			continue
+		}
+		picked := []int{anchorIdx}
+		for _, idx := range subset {
+			picked = append(picked, poolIdx[idx])
+		}
+		// 补齐的单元之间也可能有冲突
+		if !pickedCanPair(base, elems, picked) {
+			continue
+		}
+		return picked
+	}
+	return nil
+}
+
+func (nma *NormalMatchAchieve) DoThreadMatch(base *MatchJobBase) {
+	need := base.QueMap.MatchTotalNeed
+	if need <= 0 {
+		return
+	}
+	sideSize := base.QueMap.MatchSingleMax
+	if sideSize <= 0 || sideSize > need {
+		sideSize = need
+	}
+	if need%sideSize != 0 {
+		xlog.Errorf("<queue_match> normal match map=%d totalNeed=%d not divisible by singleMax=%d",
+			base.QueMap.MapID, need, sideSize)
+		return
+	}
+
+	// 先来先匹配, 优先通道提前
+	elems := make([]*MatchElem, 0, len(base.QueElems))
+	for _, oneElem := range base.QueElems {
+		if oneElem.ElemData.GamerNum() > 0 {
+			elems = append(elems, oneElem)
+		}
+	}
+	SortElemByOrder(elems)
+
+	// 每次凑一局, 直到凑不满或达到单次job上限
+	used := make([]bool, len(elems))
+	for matchIdx := 0; matchIdx < base.MaxMatchNum; matchIdx++ {
+		sides := nma.matchOne(base, elems, used, need, sideSize)
+		if sides == nil {
+			return
+		}
+		result := base.ResultAt(matchIdx)
+		for _, oneSide := range sides {
+			result.AddGroup(oneSide.Elems...)
+		}
+		if len(sides) > 1 {
+			result.Sides = sides
+		}
+	}
+}
+
+// 凑一局, 逐组装满. 返回所有组
+func (nma *NormalMatchAchieve) matchOne(base *MatchJobBase, elems []*MatchElem, used []bool,
+	need int32, sideSize int32) []*MatchSide {
+	sides := make([]*MatchSide, 0, need/sideSize)
+	chosen := make([]*MatchElem, 0, need)
+	for sideIdx := int32(0); sideIdx < need/sideSize; sideIdx++ {
+		picked := nma.packSide(base, elems, used, chosen, sideSize)
+		if picked == nil {
+			return nil
+		}
+		oneSide := newMatchSide()
+		for _, idx := range picked {
+			used[idx] = true
+			oneSide.addElem(elems[idx])
+			chosen = append(chosen, elems[idx])
+		}
+		sides = append(sides, oneSide)
+	}
+	return sides
+}
+
+func (nma *NormalMatchAchieve) CreateNewSelf() IMatchAchieve {
+	newSelf := *nma
+	return &newSelf
+}
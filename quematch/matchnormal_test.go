@@ -0,0 +1,77 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalMatchPacksMixedParties(t *testing.T) {
+	trace := testTraceInOrder(MatchStrategyNormal,
+		newTestElem(1, 0, 0),
+		newTestElem(2, 0, 0, 0),
+		newTestElem(3, 0, 0),
+		newTestElem(4, 0),
+		newTestElem(5, 0, 0, 0),
+		newTestElem(6, 0),
+		newTestElem(7, 0, 0),
+	)
+	results := simMatch(t, MapInfo{MatchTotalNeed: 10, MatchSingleMax: 5}, trace, 3*time.Second, nil)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkResultElems(t, results[0], 1, 2, 3, 5)
+	checkSides(t, results[0].Sides, []uint64{1, 2}, []uint64{3, 5})
+}
+
+func TestNormalMatchOldestFirst(t *testing.T) {
+	// 3人组先来, 只有和后面的双人组才能凑满5人, 单人要等下一局
+	trace := testTraceInOrder(MatchStrategyNormal,
+		newTestElem(1, 0, 0, 0),
+		newTestElem(2, 0),
+		newTestElem(3, 0),
+		newTestElem(4, 0, 0),
+	)
+	results := simMatch(t, MapInfo{MatchTotalNeed: 5}, trace, 3*time.Second, nil)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkResultElems(t, results[0], 1, 2, 3)
+}
+
+func TestNormalMatchInfeasibleQueue(t *testing.T) {
+	// 全是双人组永远凑不出5人一组, 不能每个锚点都做一次子集和搜索
+	elems := make([]*MatchElem, 0, 3000)
+	for i := 0; i < cap(elems); i++ {
+		elems = append(elems, newTestElem(uint64(i+1), 0, 0))
+	}
+	base := newMatchJob(&NormalMatchAchieve{})
+	base.QueElems = elems
+	base.QueMap = MapInfo{MapID: testMapID, MatchTotalNeed: 10, MatchSingleMax: 5}
+	base.MaxMatchNum = 1
+	start := time.Now()
+	base.DoThreadMatch(base)
+	if len(base.QueResult.Groups) != 0 {
+		t.Fatalf("matched infeasible queue: %v", resultElemIDs(base.QueResult))
+	}
+	if cost := time.Since(start); cost > 5*time.Second {
+		t.Fatalf("infeasible queue took %v", cost)
+	}
+}
+
+func TestPackOldestFirstShortestPrefix(t *testing.T) {
+	pool := []*MatchElem{
+		newTestElem(1, 0, 0, 0),
+		newTestElem(2, 0, 0),
+		newTestElem(3, 0, 0, 0),
+		newTestElem(4, 0),
+		newTestElem(5, 0, 0),
+	}
+	// 凑4人: 前4个中3+1最早凑满
+	subset := packOldestFirst(pool, 4)
+	if len(subset) != 2 || subset[0] != 0 || subset[1] != 3 {
+		t.Fatalf("subset=%v, want [0 3]", subset)
+	}
+	if subset = packOldestFirst(pool, 20); subset != nil {
+		t.Fatalf("subset=%v, want nil", subset)
+	}
+}
@@ -0,0 +1,164 @@
+package quematch
+
+import (
+	"math"
+	"time"
+)
+
+/*
+	matchpenalty.go: 拒绝匹配确认/频繁取消匹配的惩罚
+	违规次数越多锁定越久, 一段时间不违规次数会衰减. 锁定期间不能EnterWaitQueue
+*/
+
+// 惩罚配置
+type MatchPenaltyCfg struct {
+	FreeCount       int32   // 免罚次数, 违规次数超过该值才开始锁定
+	BaseSecond      int64   // 第一次锁定秒数, <=0表示不开启惩罚
+	Factor          float64 // 每多违规一次锁定时间乘以倍率, <1按1处理
+	MaxSecond       int64   // 锁定秒数上限, <=0表示不限
+	DecaySecond     int64   // 每隔多少秒不违规, 违规次数减1. <=0表示不衰减
+	PenaltyOnCancel bool    // 主动取消匹配是否算违规
+}
+
+// 单个玩家的违规记录
+type penaltyRecord struct {
+	count     int32     // 违规次数
+	lastTime  time.Time // 上次违规(或衰减结算)时间
+	lockUntil time.Time // 锁定到什么时候
+}
+
+// 惩罚记录
+type matchPenalty struct {
+	cfg     MatchPenaltyCfg
+	records map[MatchElemKey]*penaltyRecord
+}
+
+func newMatchPenalty() *matchPenalty {
+	return &matchPenalty{
+		records: make(map[MatchElemKey]*penaltyRecord),
+	}
+}
+
+func (mp *matchPenalty) enable() bool {
+	return mp.cfg.BaseSecond > 0
+}
+
+// 结算衰减, 记录没用了返回nil
+func (mp *matchPenalty) getRecord(key MatchElemKey, now time.Time) *penaltyRecord {
+	record, ok := mp.records[key]
+	if !ok {
+		return nil
+	}
+	if mp.cfg.DecaySecond > 0 && record.count > 0 {
+		decayGap := time.Duration(mp.cfg.DecaySecond) * time.Second
+		steps := int32(now.Sub(record.lastTime) / decayGap)
+		if steps > 0 {
+			if steps > record.count {
+				steps = record.count
+			}
+			record.count -= steps
+			record.lastTime = record.lastTime.Add(time.Duration(steps) * decayGap)
+		}
+	}
+	if record.count <= 0 && !now.Before(record.lockUntil) {
+		delete(mp.records, key)
+		return nil
+	}
+	return record
+}
+
+// 违规count次的锁定秒数
+func (mp *matchPenalty) lockSecond(count int32) int64 {
+	overCount := count - mp.cfg.FreeCount
+	if overCount <= 0 {
+		return 0
+	}
+	factor := mp.cfg.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	lock := float64(mp.cfg.BaseSecond) * math.Pow(factor, float64(overCount-1))
+	if mp.cfg.MaxSecond > 0 && lock > float64(mp.cfg.MaxSecond) {
+		return mp.cfg.MaxSecond
+	}
+	if lock > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int64(lock)
+}
+
+// 增加一次违规, 返回锁定秒数
+func (mp *matchPenalty) add(key MatchElemKey, now time.Time) int64 {
+	record := mp.getRecord(key, now)
+	if record == nil {
+		record = &penaltyRecord{}
+		mp.records[key] = record
+	}
+	record.count++
+	record.lastTime = now
+	lock := mp.lockSecond(record.count)
+	if lockUntil := now.Add(time.Duration(lock) * time.Second); lockUntil.After(record.lockUntil) {
+		record.lockUntil = lockUntil
+	}
+	return lock
+}
+
+// 剩余锁定秒数
+func (mp *matchPenalty) remain(key MatchElemKey, now time.Time) int64 {
+	record := mp.getRecord(key, now)
+	if record == nil || !now.Before(record.lockUntil) {
+		return 0
+	}
+	return int64(math.Ceil(record.lockUntil.Sub(now).Seconds()))
+}
+
+// 设置惩罚配置
+func (mqm *MatchQueueMgr) SetPenaltyCfg(cfg MatchPenaltyCfg) {
+	mqm.penalty.cfg = cfg
+}
+
+// 记录一次违规, 返回锁定秒数
+func (mqm *MatchQueueMgr) AddPenalty(gamerID uint64) int64 {
+	if !mqm.penalty.enable() {
+		return 0
+	}
+	return mqm.penalty.add(MatchElemKey{ElemType: MatchElemPerson, ElemID: gamerID}, mqm.now())
+}
+
+// 获取剩余锁定秒数, 0表示没有锁定
+func (mqm *MatchQueueMgr) GetPenaltyRemain(gamerID uint64) int64 {
+	return mqm.penalty.remain(MatchElemKey{ElemType: MatchElemPerson, ElemID: gamerID}, mqm.now())
+}
+
+// 清除惩罚
+func (mqm *MatchQueueMgr) ClearPenalty(gamerID uint64) {
+	delete(mqm.penalty.records, MatchElemKey{ElemType: MatchElemPerson, ElemID: gamerID})
+}
+
+// elem中是否有玩家在锁定中, 返回锁定最久的玩家和剩余秒数
+func (mqm *MatchQueueMgr) CheckElemPenalty(elem *MatchElem) (uint64, int64) {
+	var lockGamer uint64
+	var lockRemain int64
+	for _, oneKey := range elem.allTypeKey() {
+		if oneKey.ElemType != MatchElemPerson {
+			continue
+		}
+		if remain := mqm.GetPenaltyRemain(oneKey.ElemID); remain > lockRemain {
+			lockGamer = oneKey.ElemID
+			lockRemain = remain
+		}
+	}
+	return lockGamer, lockRemain
+}
+
+// 按离开原因记录违规
+func (mqm *MatchQueueMgr) penaltyOnLeave(elem *MatchElem, reason MatchLeaveReason) {
+	if reason != LeaveReasonCancel || !mqm.penalty.cfg.PenaltyOnCancel {
+		return
+	}
+	for _, oneKey := range elem.allTypeKey() {
+		if oneKey.ElemType == MatchElemPerson {
+			mqm.AddPenalty(oneKey.ElemID)
+		}
+	}
+}
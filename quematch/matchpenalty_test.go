@@ -0,0 +1,101 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 新建用虚拟时钟的mgr
+func newPenaltyMgr(cfg MatchPenaltyCfg) (*MatchQueueMgr, *VirtualClock) {
+	mqm := NewMatchQueueMgr(nil)
+	clock := NewVirtualClock(testStart)
+	mqm.SetMatchClock(clock)
+	mqm.SetPenaltyCfg(cfg)
+	return mqm, clock
+}
+
+// 超过免罚次数后每次锁定时间翻倍, 不超过上限
+func TestPenaltyEscalates(t *testing.T) {
+	mqm, _ := newPenaltyMgr(MatchPenaltyCfg{FreeCount: 1, BaseSecond: 10, Factor: 2, MaxSecond: 30})
+	for i, want := range []int64{0, 10, 20, 30, 30} {
+		if lock := mqm.AddPenalty(1); lock != want {
+			t.Fatalf("penalty %d lock=%d, want %d", i+1, lock, want)
+		}
+	}
+	if remain := mqm.GetPenaltyRemain(1); remain != 30 {
+		t.Fatalf("remain=%d, want 30", remain)
+	}
+	if remain := mqm.GetPenaltyRemain(2); remain != 0 {
+		t.Fatalf("other gamer remain=%d, want 0", remain)
+	}
+}
+
+// 不违规的时间每过DecaySecond违规次数减1, 减到0删掉记录
+func TestPenaltyDecay(t *testing.T) {
+	mqm, clock := newPenaltyMgr(MatchPenaltyCfg{BaseSecond: 10, Factor: 2, DecaySecond: 60})
+	mqm.AddPenalty(1)
+	if lock := mqm.AddPenalty(1); lock != 20 {
+		t.Fatalf("second lock=%d, want 20", lock)
+	}
+	clock.Advance(20 * time.Second)
+	if remain := mqm.GetPenaltyRemain(1); remain != 0 {
+		t.Fatalf("remain after lock=%d, want 0", remain)
+	}
+	// 衰减一次, 剩1次, 再违规算第2次
+	clock.Advance(41 * time.Second)
+	if lock := mqm.AddPenalty(1); lock != 20 {
+		t.Fatalf("lock after one decay=%d, want 20", lock)
+	}
+	clock.Advance(200 * time.Second)
+	mqm.GetPenaltyRemain(1)
+	if len(mqm.penalty.records) != 0 {
+		t.Fatal("record not removed after full decay")
+	}
+	if lock := mqm.AddPenalty(1); lock != 10 {
+		t.Fatalf("lock after full decay=%d, want 10", lock)
+	}
+}
+
+// 锁定期间不能进队, 组队中有人锁定整队不能进
+func TestPenaltyBlocksEnter(t *testing.T) {
+	mqm, clock := newPenaltyMgr(MatchPenaltyCfg{BaseSecond: 10})
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	mqm.AddPenalty(1)
+	mqm.AddPenalty(21)
+	if mqm.EnterWaitQueue(queKey, newTestElem(1, 0)) {
+		t.Fatal("locked gamer entered queue")
+	}
+	team := newTestElem(2, 0, 0)
+	if gamerID, remain := mqm.CheckElemPenalty(team); gamerID != 21 || remain != 10 {
+		t.Fatalf("CheckElemPenalty=(%d, %d), want (21, 10)", gamerID, remain)
+	}
+	if mqm.EnterWaitQueue(queKey, team) {
+		t.Fatal("team with locked member entered queue")
+	}
+	clock.Advance(10 * time.Second)
+	if !mqm.EnterWaitQueue(queKey, newTestElem(1, 0)) || !mqm.EnterWaitQueue(queKey, team) {
+		t.Fatal("enter failed after lock ended")
+	}
+}
+
+// 只有开启PenaltyOnCancel时主动取消才算违规, 其他离开原因不算
+func TestPenaltyOnCancel(t *testing.T) {
+	tests := []struct {
+		onCancel bool
+		reason   MatchLeaveReason
+		remain   int64
+	}{
+		{false, LeaveReasonCancel, 0},
+		{true, LeaveReasonCancel, 10},
+		{true, LeaveReasonTimeout, 0},
+	}
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	for _, tt := range tests {
+		mqm, _ := newPenaltyMgr(MatchPenaltyCfg{BaseSecond: 10, PenaltyOnCancel: tt.onCancel})
+		mqm.EnterWaitQueue(queKey, newTestElem(1, 0))
+		mqm.LeaveQueue(testElemKey(1), tt.reason)
+		if remain := mqm.GetPenaltyRemain(1); remain != tt.remain {
+			t.Fatalf("onCancel=%t, reason=%v: remain=%d, want %d", tt.onCancel, tt.reason, remain, tt.remain)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xlog"
+	"github.com/qixi7/xengine_pub/structimpl/dispatchcollector"
+	"time"
+)
+
+/*
+	matchready.go: 匹配确认(ready check)
+	开启后匹配结果不会直接MatchSuccess, 而是先把elem移出队列暂存, 对每个玩家发起一次确认收集:
+	全部接受 -> MatchSuccess; 有人拒绝或超时 -> 拒绝者离开匹配, 其余人按原StartTime放回原队列原来的位置.
+	组队的成员个人key也算在确认中, 确认期间不能进队, 离开匹配当作拒绝
+*/
+
+// 匹配确认通知(业务实现)
+type IMatchReadyNotify interface {
+	OnReadyCheckStart(checkID uint32, result *MatchResult)                         // 通知玩家确认
+	OnReadyCheckOne(checkID uint32, gamerID uint64, accept bool)                   // 某个玩家确认结果
+	OnReadyCheckFailed(checkID uint32, result *MatchResult, refuseGamers []uint64) // 确认失败
+}
+
+// 暂存的elem
+type readyHeldElem struct {
+	elem    *MatchElem      // 原始elem
+	queKeys []MatchQueueKey // 原来所在的队列, [0]为主队列
+}
+
+// 一次匹配确认
+type readyCheck struct {
+	mqm      *MatchQueueMgr
+	checkID  uint32
+	queKey   MatchQueueKey
+	cliKey   ClientKey
+	mapInfo  MapInfo
+	result   *MatchResult
+	held     []*readyHeldElem
+	gamer2ID map[uint64]uint32 // gamerID -> 收集key
+	id2Gamer map[uint32]uint64 // 收集key -> gamerID
+}
+
+// 开启匹配确认, collMgr为nil时关闭
+func (mqm *MatchQueueMgr) EnableReadyCheck(collMgr *dispatchcollector.DispatchCollectMgr,
+	timeout time.Duration, notify IMatchReadyNotify) {
+	mqm.readyCollMgr = collMgr
+	mqm.readyTimeout = timeout
+	mqm.readyNotify = notify
+}
+
+func (mqm *MatchQueueMgr) readyCheckOn() bool {
+	return mqm.readyCollMgr != nil && mqm.readyNotify != nil
+}
+
+// 把elem从所有队列中移出, 不回调OnLeaveQueue
+func (mqm *MatchQueueMgr) holdElem(elemKey MatchElemKey) *readyHeldElem {
+	elem, _ := mqm.findMatchElem(elemKey)
+	if elem == nil {
+		return nil
+	}
+	held := &readyHeldElem{
+		elem:    elem,
+		queKeys: mqm.GetElemQueueKeys(elemKey),
+	}
+	for _, queKey := range held.queKeys {
+		if matchQue := mqm.findMatchQueue(queKey); matchQue != nil {
+			matchQue.delMatch(elemKey)
+		}
+	}
+	delete(mqm.elemTickets, elemKey)
+	delete(mqm.elem2MatchQueue, elemKey)
+	return held
+}
+
+// 把暂存的elem放回原队列原来的位置, 不回调OnEnterQueue
+func (mqm *MatchQueueMgr) restoreElem(held *readyHeldElem) {
+	if mqm.findQueKeyByElemKey(held.elem.ElemKey) != nil {
+		return
+	}
+	// 确认期间业务可能修改了elem, 重新生成只读副本
+	held.elem.frozen = nil
+	for _, queKey := range held.queKeys {
+		mqm.getOrNewQueue(queKey).restoreMatch(held.elem)
+	}
+	mqm.elem2MatchQueue[held.elem.ElemKey] = held.queKeys[0]
+	if len(held.queKeys) > 1 {
+		mqm.elemTickets[held.elem.ElemKey] = held.queKeys
+	}
+	xlog.InfoF("<queue_match> ready check requeue: queKey=%v, elem=%v", held.queKeys[0], *held.elem)
+}
+
+// 开始一次匹配确认
+func (mqm *MatchQueueMgr) startReadyCheck(queKey MatchQueueKey, cliKey ClientKey, mapInfo MapInfo,
+	result *MatchResult) {
+	check := &readyCheck{
+		mqm:      mqm,
+		queKey:   queKey,
+		cliKey:   cliKey,
+		mapInfo:  mapInfo,
+		result:   result,
+		held:     make([]*readyHeldElem, 0, len(result.Groups)),
+		gamer2ID: make(map[uint64]uint32),
+		id2Gamer: make(map[uint32]uint64),
+	}
+	result.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+		if held := mqm.holdElem(oneElem.ElemKey); held != nil {
+			check.held = append(check.held, held)
+		}
+	})
+	coll := mqm.readyCollMgr.CreateOneCollect(mqm.readyTimeout, check)
+	check.checkID = coll.GetCollID()
+	for _, held := range check.held {
+		data, ok := held.elem.ElemData.(*ScoreMatchElemData)
+		if !ok {
+			continue
+		}
+		for _, oneGamer := range data.Gamers {
+			collKey := uint32(len(check.id2Gamer) + 1)
+			check.gamer2ID[oneGamer.GamerID] = collKey
+			check.id2Gamer[collKey] = oneGamer.GamerID
+			coll.AddOneCollect(collKey, nil)
+		}
+		// 组队的成员个人key也记下, 确认期间成员不能单独进队
+		for _, oneKey := range held.elem.allTypeKey() {
+			mqm.readyHeld[*oneKey] = check.checkID
+		}
+	}
+	mqm.readyChecks[check.checkID] = check
+	xlog.InfoF("<queue_match> ready check start: checkID=%d, queKey=%v", check.checkID, queKey)
+	mqm.readyNotify.OnReadyCheckStart(check.checkID, result)
+}
+
+// 玩家确认匹配结果
+func (mqm *MatchQueueMgr) ReadyCheckAnswer(checkID uint32, gamerID uint64, accept bool) bool {
+	check, ok := mqm.readyChecks[checkID]
+	if !ok {
+		return false
+	}
+	collKey, ok := check.gamer2ID[gamerID]
+	if !ok {
+		return false
+	}
+	mqm.readyCollMgr.CollectOne(checkID, collKey, accept)
+	return true
+}
+
+// elem是否在匹配确认中, 返回checkID
+func (mqm *MatchQueueMgr) FindReadyCheck(elemKey MatchElemKey) (uint32, bool) {
+	checkID, ok := mqm.readyHeld[elemKey]
+	return checkID, ok
+}
+
+// 匹配确认中的elem主动离开当作拒绝. elemKey是组队的key时算队长拒绝, 是成员个人key时算该成员拒绝
+func (mqm *MatchQueueMgr) leaveReadyCheck(elemKey MatchElemKey) bool {
+	checkID, ok := mqm.readyHeld[elemKey]
+	if !ok {
+		return false
+	}
+	check := mqm.readyChecks[checkID]
+	for _, held := range check.held {
+		data, ok := held.elem.ElemData.(*ScoreMatchElemData)
+		if !ok || len(data.Gamers) <= 0 {
+			continue
+		}
+		if held.elem.ElemKey == elemKey {
+			mqm.ReadyCheckAnswer(checkID, data.Gamers[0].GamerID, false)
+			return true
+		}
+		if elemKey.ElemType != MatchElemPerson {
+			continue
+		}
+		for _, oneGamer := range data.Gamers {
+			if oneGamer.GamerID == elemKey.ElemID {
+				mqm.ReadyCheckAnswer(checkID, oneGamer.GamerID, false)
+				return true
+			}
+		}
+	}
+	return true
+}
+
+// 结束一次匹配确认
+func (mqm *MatchQueueMgr) endReadyCheck(check *readyCheck) {
+	for _, held := range check.held {
+		for _, oneKey := range held.elem.allTypeKey() {
+			delete(mqm.readyHeld, *oneKey)
+		}
+	}
+	delete(mqm.readyChecks, check.checkID)
+}
+
+// 关闭时把所有确认中的elem放回队列
+func (mqm *MatchQueueMgr) cancelReadyChecks() {
+	for _, check := range mqm.readyChecks {
+		for _, held := range check.held {
+			mqm.restoreElem(held)
+		}
+		mqm.endReadyCheck(check)
+	}
+}
+
+// --------------------------- impl dispatchInterface ---------------------------
+
+func (rc *readyCheck) OnCollectOne(collID uint32, roleID uint32, accept bool) {
+	rc.mqm.readyNotify.OnReadyCheckOne(collID, rc.id2Gamer[roleID], accept)
+}
+
+func (rc *readyCheck) OnCollectSuccess(collID uint32) {
+	mqm := rc.mqm
+	mqm.endReadyCheck(rc)
+	allok := mqm.successDo.MatchSuccess(rc.result, rc.cliKey, rc.mapInfo)
+	queStat := mqm.getQueueStat(rc.queKey)
+	if !allok {
+		queStat.counter.successRefuse++
+		for _, held := range rc.held {
+			mqm.restoreElem(held)
+		}
+		return
+	}
+	mqm.recordMatched(rc.queKey, rc.result, false)
+	mqm.constraints.addRecentMatch(rc.result, mqm.now())
+	xlog.InfoF("<queue_match> ready check success: checkID=%d, queKey=%v", collID, rc.queKey)
+	for _, held := range rc.held {
+		held.elem.OnLeaveQueue(held.queKeys[0], held.elem, LeaveReasonSuccess)
+		queStat.counter.queueWait.observe(mqm.since(held.elem.StartTime).Seconds())
+	}
+}
+
+func (rc *readyCheck) OnCollectFailed(collID uint32, roleID uint32) {
+	mqm := rc.mqm
+	mqm.endReadyCheck(rc)
+	// 有人拒绝时只算拒绝的人, 否则是超时, 没有接受的都算拒绝
+	refuseSet := make(map[uint64]struct{})
+	if coll := mqm.readyCollMgr.GetCollect(collID); coll != nil {
+		timeoutSet := make(map[uint64]struct{})
+		coll.ForeachColl(func(collKey uint32, state int, _ interface{}) bool {
+			switch state {
+			case dispatchcollector.ECollectRefuse:
+				refuseSet[rc.id2Gamer[collKey]] = struct{}{}
+			case dispatchcollector.ECollectUnknown:
+				timeoutSet[rc.id2Gamer[collKey]] = struct{}{}
+			}
+			return true
+		})
+		if len(refuseSet) <= 0 {
+			refuseSet = timeoutSet
+		}
+	} else {
+		refuseSet[rc.id2Gamer[roleID]] = struct{}{}
+	}
+	refuseGamers := make([]uint64, 0, len(refuseSet))
+	for _, held := range rc.held {
+		refused := false
+		if data, ok := held.elem.ElemData.(*ScoreMatchElemData); ok {
+			for _, oneGamer := range data.Gamers {
+				if _, ok := refuseSet[oneGamer.GamerID]; ok {
+					refused = true
+					refuseGamers = append(refuseGamers, oneGamer.GamerID)
+				}
+			}
+		}
+		if !refused {
+			mqm.restoreElem(held)
+			continue
+		}
+		// 拒绝者离开匹配
+		held.elem.OnLeaveQueue(held.queKeys[0], held.elem, LeaveReasonReadyRefuse)
+		mqm.getQueueStat(held.queKeys[0]).counter.queueWait.observe(mqm.since(held.elem.StartTime).Seconds())
+		xlog.InfoF("<queue_match> ready check refuse: checkID=%d, elem=%v", collID, *held.elem)
+	}
+	// 拒绝者记一次违规
+	for _, gamerID := range refuseGamers {
+		mqm.AddPenalty(gamerID)
+	}
+	mqm.readyNotify.OnReadyCheckFailed(collID, rc.result, refuseGamers)
+}
@@ -0,0 +1,201 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xcontainer/timer"
+	"github.com/qixi7/xengine_pub/structimpl/dispatchcollector"
+	"testing"
+	"time"
+)
+
+var testReadyQueKey = MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+
+type testTimerGetter struct {
+	ctl *timer.Controller
+}
+
+func (g *testTimerGetter) GetTimerMgr() *timer.Controller {
+	return g.ctl
+}
+
+// 记录确认通知
+type testReadyNotify struct {
+	starts  []uint32
+	answers map[uint64]bool
+	refuses [][]uint64
+}
+
+func (n *testReadyNotify) OnReadyCheckStart(checkID uint32, _ *MatchResult) {
+	n.starts = append(n.starts, checkID)
+}
+
+func (n *testReadyNotify) OnReadyCheckOne(_ uint32, gamerID uint64, accept bool) {
+	n.answers[gamerID] = accept
+}
+
+func (n *testReadyNotify) OnReadyCheckFailed(_ uint32, _ *MatchResult, refuseGamers []uint64) {
+	n.refuses = append(n.refuses, refuseGamers)
+}
+
+// 新建开启匹配确认的模拟mgr, elems每隔1秒进队
+func newReadyColl(t *testing.T, collOK *testCollOK, timeout time.Duration,
+	elems ...*MatchElem) (*MatchDataCollector, *timer.Controller, *testReadyNotify) {
+	t.Helper()
+	coll := newRepairColl(t, collOK, testReadyQueKey, 2)
+	ctl := timer.New()
+	notify := &testReadyNotify{answers: make(map[uint64]bool)}
+	coll.GetMatchMgr().EnableReadyCheck(dispatchcollector.NewDispatchCollectMgr(&testTimerGetter{ctl: ctl}),
+		timeout, notify)
+	for _, oneElem := range elems {
+		if !coll.PushMatchElem(testReadyQueKey, oneElem) {
+			t.Fatal("PushMatchElem failed")
+		}
+		coll.simClock.Advance(time.Second)
+	}
+	return coll, ctl, notify
+}
+
+// 队列中的elemID, 按队列顺序
+func queueElemIDs(mqm *MatchQueueMgr, queKey MatchQueueKey) []uint64 {
+	ids := make([]uint64, 0)
+	mqm.findMatchQueue(queKey).matchElems.foreach(func(oneElem *MatchElem) bool {
+		ids = append(ids, oneElem.ElemKey.ElemID)
+		return true
+	})
+	return ids
+}
+
+func checkQueueElems(t *testing.T, mqm *MatchQueueMgr, ids ...uint64) {
+	t.Helper()
+	got := queueElemIDs(mqm, testReadyQueKey)
+	if len(got) != len(ids) {
+		t.Fatalf("queue elems=%v, want %v", got, ids)
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Fatalf("queue elems=%v, want %v", got, ids)
+		}
+	}
+}
+
+func checkLeaves(t *testing.T, elem *MatchElem, reasons ...MatchLeaveReason) {
+	t.Helper()
+	leaves := elemLeaves(elem)
+	if len(leaves) != len(reasons) {
+		t.Fatalf("elem %d leaves=%v, want %v", elem.ElemKey.ElemID, leaves, reasons)
+	}
+	for i := range reasons {
+		if leaves[i] != reasons[i] {
+			t.Fatalf("elem %d leaves=%v, want %v", elem.ElemKey.ElemID, leaves, reasons)
+		}
+	}
+}
+
+// 全部接受才算成局
+func TestReadyCheckAccept(t *testing.T) {
+	collOK := &testCollOK{}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0)}
+	coll, _, notify := newReadyColl(t, collOK, time.Minute, elems...)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	newTestMatchJob(mqm, testReadyQueKey, elems[:2]).DoReturn()
+	if len(collOK.results) != 0 || len(notify.starts) != 1 {
+		t.Fatalf("results=%d, starts=%d, want 0 and 1", len(collOK.results), len(notify.starts))
+	}
+	checkID := notify.starts[0]
+	if id, ok := mqm.FindReadyCheck(testElemKey(1)); !ok || id != checkID {
+		t.Fatal("elem 1 not in ready check")
+	}
+	checkQueueElems(t, mqm, 3)
+	if !mqm.ReadyCheckAnswer(checkID, 10, true) || len(collOK.results) != 0 {
+		t.Fatal("matched before all accepted")
+	}
+	mqm.ReadyCheckAnswer(checkID, 20, true)
+	if len(collOK.results) != 1 {
+		t.Fatalf("got %d results after all accepted, want 1", len(collOK.results))
+	}
+	checkResultElems(t, collOK.results[0], 1, 2)
+	if _, ok := mqm.FindReadyCheck(testElemKey(1)); ok {
+		t.Fatal("ready check not ended")
+	}
+	checkLeaves(t, elems[0], LeaveReasonSuccess)
+	if !notify.answers[10] || !notify.answers[20] {
+		t.Fatalf("answers=%v, want both accepted", notify.answers)
+	}
+}
+
+// 有人拒绝时拒绝者离开, 其余人按进队时间放回原来的位置
+func TestReadyCheckRefuseRestoresPosition(t *testing.T) {
+	collOK := &testCollOK{}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0)}
+	coll, _, notify := newReadyColl(t, collOK, time.Minute, elems...)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	startTime := elems[0].StartTime
+	newTestMatchJob(mqm, testReadyQueKey, []*MatchElem{elems[0], elems[2]}).DoReturn()
+	checkQueueElems(t, mqm, 2)
+	mqm.ReadyCheckAnswer(notify.starts[0], 30, false)
+	if len(collOK.results) != 0 {
+		t.Fatal("matched after refuse")
+	}
+	if len(notify.refuses) != 1 || len(notify.refuses[0]) != 1 || notify.refuses[0][0] != 30 {
+		t.Fatalf("refuses=%v, want [[30]]", notify.refuses)
+	}
+	checkLeaves(t, elems[2], LeaveReasonReadyRefuse)
+	checkLeaves(t, elems[0])
+	checkQueueElems(t, mqm, 1, 2)
+	if !elems[0].StartTime.Equal(startTime) {
+		t.Fatal("restored elem StartTime changed")
+	}
+	if _, ok := mqm.FindReadyCheck(testElemKey(1)); ok {
+		t.Fatal("ready check not ended")
+	}
+}
+
+// 超时没有接受的算拒绝
+func TestReadyCheckTimeout(t *testing.T) {
+	collOK := &testCollOK{}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0)}
+	coll, ctl, notify := newReadyColl(t, collOK, time.Nanosecond, elems...)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	newTestMatchJob(mqm, testReadyQueKey, elems).DoReturn()
+	mqm.ReadyCheckAnswer(notify.starts[0], 10, true)
+	time.Sleep(time.Millisecond)
+	ctl.Tick()
+	if len(notify.refuses) != 1 || len(notify.refuses[0]) != 1 || notify.refuses[0][0] != 20 {
+		t.Fatalf("refuses=%v, want [[20]]", notify.refuses)
+	}
+	checkLeaves(t, elems[1], LeaveReasonReadyRefuse)
+	checkQueueElems(t, mqm, 1)
+}
+
+// 确认中组队成员不能单独进队, 成员离开算该成员拒绝
+func TestReadyCheckLeaveRefuses(t *testing.T) {
+	collOK := &testCollOK{}
+	team := newTestElem(1, 0, 0)
+	elems := []*MatchElem{team, newTestElem(2, 0)}
+	coll, _, notify := newReadyColl(t, collOK, time.Minute, elems...)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	newTestMatchJob(mqm, testReadyQueKey, elems).DoReturn()
+	checkID := notify.starts[0]
+	if id, ok := mqm.FindReadyCheck(testElemKey(11)); !ok || id != checkID {
+		t.Fatal("team member key not held")
+	}
+	if mqm.EnterWaitQueue(testReadyQueKey, newTestElem(11, 0)) {
+		t.Fatal("team member entered queue during ready check")
+	}
+	if !mqm.LeaveQueue(testElemKey(11), LeaveReasonCancel) {
+		t.Fatal("LeaveQueue failed during ready check")
+	}
+	if len(notify.refuses) != 1 || len(notify.refuses[0]) != 1 || notify.refuses[0][0] != 11 {
+		t.Fatalf("refuses=%v, want [[11]]", notify.refuses)
+	}
+	checkLeaves(t, team, LeaveReasonReadyRefuse)
+	checkQueueElems(t, mqm, 2)
+	for _, elemKey := range []MatchElemKey{team.ElemKey, testElemKey(10), testElemKey(11)} {
+		if _, ok := mqm.FindReadyCheck(elemKey); ok {
+			t.Fatalf("key %v still held", elemKey)
+		}
+	}
+}
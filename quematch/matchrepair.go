@@ -0,0 +1,203 @@
+package quematch
+
+import (
+	"sort"
+)
+
+/*
+	matchrepair.go: 匹配结果修补
+	匹配线程算完后, 结果中的elem可能已经取消匹配或被其他结果用掉. 策略实现IMatchRepair时,
+	主线程用队列中剩下的elem补上缺的人, 而不是整个结果作废
+*/
+
+// 匹配结果修补(可选, 由IMatchAchieve实现). 主线程调用, 不要做耗时操作.
+// lost为结果中失效的elem, pool为队列中还能用的elem(按优先级排序). 修补成功返回true
+type IMatchRepair interface {
+	RepairMatch(base *MatchJobBase, result *MatchResult, lost []*MatchElem, pool []*MatchElem) bool
+}
+
+// 结果中失效的elem: 已不在匹配中, 被前面的结果用掉, 或者在结果中重复
+func lostResultElems(queMgr *MatchQueueMgr, result *MatchResult, used map[MatchElemKey]struct{}) []*MatchElem {
+	lost := make([]*MatchElem, 0)
+	seen := make(map[MatchElemKey]struct{}, len(result.Groups))
+	result.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+		_, isUsed := used[oneElem.ElemKey]
+		_, isSeen := seen[oneElem.ElemKey]
+		seen[oneElem.ElemKey] = struct{}{}
+		if isUsed || isSeen {
+			lost = append(lost, oneElem)
+			return
+		}
+		if findElem, _ := queMgr.findMatchElem(oneElem.ElemKey); findElem == nil {
+			lost = append(lost, oneElem)
+		}
+	})
+	return lost
+}
+
+// 可用于修补的elem: 队列中没有被用掉且不在该结果中的
+func (mj *MatchJobBase) repairPool(queMgr *MatchQueueMgr, result *MatchResult,
+	used map[MatchElemKey]struct{}) []*MatchElem {
+	matchQue := queMgr.findMatchQueue(mj.QueKey)
+	if matchQue == nil {
+		return nil
+	}
+	inResult := make(map[MatchElemKey]struct{}, len(result.Groups))
+	result.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+		inResult[oneElem.ElemKey] = struct{}{}
+	})
+	// 其他结果中的elem放到最后, 尽量不影响其他结果
+	inOther := make(map[MatchElemKey]struct{})
+	for _, oneResult := range mj.QueResults {
+		if oneResult == result {
+			continue
+		}
+		oneResult.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+			inOther[oneElem.ElemKey] = struct{}{}
+		})
+	}
+	view := matchQue.canMatchView()
+	pool := make([]*MatchElem, 0, len(view))
+	otherPool := make([]*MatchElem, 0)
+	for _, oneElem := range view {
+		if _, ok := used[oneElem.ElemKey]; ok {
+			continue
+		}
+		if _, ok := inResult[oneElem.ElemKey]; ok {
+			continue
+		}
+		if !mj.LatencyAccept(oneElem) {
+			continue
+		}
+		if _, ok := inOther[oneElem.ElemKey]; ok {
+			otherPool = append(otherPool, oneElem)
+			continue
+		}
+		pool = append(pool, oneElem)
+	}
+	return append(pool, otherPool...)
+}
+
+// 用pool中人数相同的elem替换lost, 按pool顺序优先. 分边结果同步替换, 职业匹配结果不支持
+func ReplaceLostElems(base *MatchJobBase, result *MatchResult, lost []*MatchElem, pool []*MatchElem) bool {
+	if len(result.RoleAssign) > 0 {
+		return false
+	}
+	lostSet := make(map[*MatchElem]struct{}, len(lost))
+	for _, oneElem := range lost {
+		lostSet[oneElem] = struct{}{}
+	}
+	// 留下来的elem
+	kept := make([]*MatchElem, 0, len(result.Groups))
+	for _, oneElem := range result.Groups {
+		if _, ok := lostSet[oneElem]; !ok {
+			kept = append(kept, oneElem)
+		}
+	}
+	// 逐个找替补
+	replace := make(map[*MatchElem]*MatchElem, len(lost))
+	taken := make([]bool, len(pool))
+	for _, lostElem := range lost {
+		for i, oneElem := range pool {
+			if taken[i] || oneElem.ElemData.GamerNum() != lostElem.ElemData.GamerNum() {
+				continue
+			}
+			if !base.CanJoin(oneElem, kept) {
+				continue
+			}
+			taken[i] = true
+			replace[lostElem] = oneElem
+			kept = append(kept, oneElem)
+			break
+		}
+		if _, ok := replace[lostElem]; !ok {
+			return false
+		}
+	}
+	// 替换结果
+	for i, oneElem := range result.Groups {
+		if newElem, ok := replace[oneElem]; ok {
+			result.Groups[i] = newElem
+		}
+	}
+	for sideIdx, oneSide := range result.Sides {
+		newSide := newMatchSide()
+		for _, oneElem := range oneSide.Elems {
+			if newElem, ok := replace[oneElem]; ok {
+				oneElem = newElem
+			}
+			newSide.addElem(oneElem)
+		}
+		result.Sides[sideIdx] = newSide
+	}
+	return true
+}
+
+// --------------------------- 内置策略修补 ---------------------------
+
+// 常规匹配: 按先来先匹配补人
+func (nma *NormalMatchAchieve) RepairMatch(base *MatchJobBase, result *MatchResult, lost []*MatchElem,
+	pool []*MatchElem) bool {
+	return ReplaceLostElems(base, result, lost, pool)
+}
+
+// 分数匹配: 用分数最接近的补人, 补完后分差仍需可以接受
+func (sma *ScoreMatchAchieve) RepairMatch(base *MatchJobBase, result *MatchResult, lost []*MatchElem,
+	pool []*MatchElem) bool {
+	if len(lost) != 1 {
+		// 缺多个时按第一个缺的分数排序不一定合适, 只修补缺一个的
+		return false
+	}
+	lostCand := newScoreMatchCand(lost[0])
+	if lostCand == nil {
+		return false
+	}
+	cands := make([]*scoreMatchCand, 0, len(pool))
+	for _, oneElem := range pool {
+		if cand := newScoreMatchCand(oneElem); cand != nil {
+			cands = append(cands, cand)
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return absInt32(cands[i].avgScore-lostCand.avgScore) < absInt32(cands[j].avgScore-lostCand.avgScore)
+	})
+	sortedPool := make([]*MatchElem, 0, len(cands))
+	for _, cand := range cands {
+		sortedPool = append(sortedPool, cand.elem)
+	}
+	if !ReplaceLostElems(base, result, lost, sortedPool) {
+		return false
+	}
+	// 两边对战时重新分边
+	if len(result.Sides) == 2 {
+		if result.Sides = BalanceTwoSides(result.Groups, base.QueMap.MatchSingleMax); result.Sides == nil {
+			return false
+		}
+	}
+	// 检查分差
+	picked := make([]int, 0, len(result.Groups))
+	resultCands := make([]*scoreMatchCand, 0, len(result.Groups))
+	var minScore, maxScore int32
+	for i, oneElem := range result.Groups {
+		cand := newScoreMatchCand(oneElem)
+		if cand == nil {
+			return false
+		}
+		if i == 0 || cand.minScore < minScore {
+			minScore = cand.minScore
+		}
+		if i == 0 || cand.maxScore > maxScore {
+			maxScore = cand.maxScore
+		}
+		picked = append(picked, i)
+		resultCands = append(resultCands, cand)
+	}
+	return sma.spreadAccept(resultCands, picked, maxScore-minScore)
+}
+
+func absInt32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
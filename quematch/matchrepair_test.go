@@ -0,0 +1,73 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 新建模拟用的collector, elem都进队但不跑匹配, 只有一个client
+func newRepairColl(t *testing.T, collOK *testCollOK, queKey MatchQueueKey, need int32,
+	elems ...*MatchElem) *MatchDataCollector {
+	t.Helper()
+	coll := NewSimDataCollector(collOK, testStart, 100*time.Millisecond)
+	if coll == nil {
+		t.Fatal("NewSimDataCollector failed")
+	}
+	coll.InitClientMapInfo(ClientKey{ServerID: 1}, MapInfo{MapID: testMapID, MatchTotalNeed: need})
+	for _, oneElem := range elems {
+		if !coll.PushMatchElem(queKey, oneElem) {
+			t.Fatal("PushMatchElem failed")
+		}
+	}
+	return coll
+}
+
+// 结果中有人取消匹配, 用队列中剩下的人补上
+func TestRepairReplacesLeftElem(t *testing.T) {
+	collOK := &testCollOK{}
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0), newTestElem(4, 0),
+		newTestElem(5, 0)}
+	coll := newRepairColl(t, collOK, queKey, 4, elems...)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	matchJob := newTestMatchJob(mqm, queKey, elems[:4])
+	mqm.LeaveQueue(testElemKey(2), LeaveReasonCancel)
+	matchJob.DoReturn()
+	if len(collOK.results) != 1 {
+		t.Fatalf("got %d results, want 1", len(collOK.results))
+	}
+	checkResultElems(t, collOK.results[0], 1, 3, 4, 5)
+	if repair := mqm.getQueueStat(queKey).counter.matchRepair; repair != 1 {
+		t.Fatalf("matchRepair=%d, want 1", repair)
+	}
+	if reasons := elemLeaves(elems[4]); len(reasons) != 1 || reasons[0] != LeaveReasonSuccess {
+		t.Fatalf("elem 5 leaves=%v, want success", reasons)
+	}
+}
+
+// 一个结果失效不影响同一个job的其他结果
+func TestLostResultKeepsOthers(t *testing.T) {
+	collOK := &testCollOK{}
+	calls := 0
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: testStrategyCustom}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0), newTestElem(4, 0)}
+	coll := newRepairColl(t, collOK, queKey, 2, elems...)
+	defer coll.EndSimulation()
+	// 不支持修补的策略
+	coll.RegisterMatchAchieve(testStrategyCustom, &sortInPlaceAchieve{calls: &calls})
+	mqm := coll.GetMatchMgr()
+	matchJob := newTestMatchJob(mqm, queKey, elems[:2], elems[2:])
+	mqm.LeaveQueue(testElemKey(3), LeaveReasonCancel)
+	matchJob.DoReturn()
+	if len(collOK.results) != 1 {
+		t.Fatalf("got %d results, want 1", len(collOK.results))
+	}
+	checkResultElems(t, collOK.results[0], 1, 2)
+	if ghost := mqm.getQueueStat(queKey).counter.ghostReject; ghost != 1 {
+		t.Fatalf("ghostReject=%d, want 1", ghost)
+	}
+	if findElem, _ := mqm.FindMatchElem(testElemKey(4)); findElem == nil {
+		t.Fatal("elem 4 of the dropped result left the queue")
+	}
+}
@@ -0,0 +1,276 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xlog"
+)
+
+/*
+	matchrole.go: 内置职业匹配算法
+	每组按MapInfo.RoleSlots职业模板凑人, 每个玩家只会分到自己选择的职业之一
+*/
+
+// 职业
+type MatchRole uint32
+
+// 职业模板中的一个职业位
+type MatchRoleSlot struct {
+	Role MatchRole // 职业
+	Num  int32     // 需要人数
+}
+
+// 职业匹配算法
+type RoleMatchAchieve struct {
+}
+
+// 一组职业匹配中的状态
+type roleMatchSide struct {
+	slots    []MatchRole        // 展开后的职业位
+	gamers   []*ScoreMatchGamer // 组内玩家
+	elems    []*MatchElem       // 组内元素
+	slotUsed []int              // 职业位 -> gamers下标, -1为空
+}
+
+func newRoleMatchSide(slots []MatchRole) *roleMatchSide {
+	return &roleMatchSide{
+		slots:  slots,
+		gamers: make([]*ScoreMatchGamer, 0, len(slots)),
+		elems:  make([]*MatchElem, 0),
+	}
+}
+
+// 尝试给组内所有玩家分配职业位(二分图最大匹配), 全部分到返回true
+func (rs *roleMatchSide) assign(gamers []*ScoreMatchGamer) ([]int, bool) {
+	slotUsed := make([]int, len(rs.slots))
+	for i := range slotUsed {
+		slotUsed[i] = -1
+	}
+	for gamerIdx := range gamers {
+		visited := make([]bool, len(rs.slots))
+		if !rs.augment(gamers, gamerIdx, slotUsed, visited) {
+			return nil, false
+		}
+	}
+	return slotUsed, true
+}
+
+// 增广路
+func (rs *roleMatchSide) augment(gamers []*ScoreMatchGamer, gamerIdx int, slotUsed []int, visited []bool) bool {
+	for slotIdx, slotRole := range rs.slots {
+		if visited[slotIdx] || !gamerHasRole(gamers[gamerIdx], slotRole) {
+			continue
+		}
+		visited[slotIdx] = true
+		if slotUsed[slotIdx] < 0 || rs.augment(gamers, slotUsed[slotIdx], slotUsed, visited) {
+			slotUsed[slotIdx] = gamerIdx
+			return true
+		}
+	}
+	return false
+}
+
+// 尝试把一个元素放进该组
+func (rs *roleMatchSide) tryAdd(elem *MatchElem, data *ScoreMatchElemData) bool {
+	if len(rs.gamers)+len(data.Gamers) > len(rs.slots) {
+		return false
+	}
+	gamers := make([]*ScoreMatchGamer, 0, len(rs.gamers)+len(data.Gamers))
+	gamers = append(gamers, rs.gamers...)
+	for i := 0; i < len(data.Gamers); i++ {
+		gamers = append(gamers, &data.Gamers[i])
+	}
+	slotUsed, ok := rs.assign(gamers)
+	if !ok {
+		return false
+	}
+	rs.gamers = gamers
+	rs.elems = append(rs.elems, elem)
+	rs.slotUsed = slotUsed
+	return true
+}
+
+// 回溯用的组内状态
+type roleSideMark struct {
+	gamers   []*ScoreMatchGamer
+	elemNum  int
+	slotUsed []int
+}
+
+func (rs *roleMatchSide) mark() roleSideMark {
+	return roleSideMark{gamers: rs.gamers, elemNum: len(rs.elems), slotUsed: rs.slotUsed}
+}
+
+// 撤销mark之后加入的元素
+func (rs *roleMatchSide) reset(mark roleSideMark) {
+	rs.gamers = mark.gamers
+	rs.elems = rs.elems[:mark.elemNum]
+	rs.slotUsed = mark.slotUsed
+}
+
+func (rs *roleMatchSide) full() bool {
+	return len(rs.gamers) == len(rs.slots)
+}
+
+func gamerHasRole(gamer *ScoreMatchGamer, role MatchRole) bool {
+	for _, oneRole := range gamer.Roles {
+		if oneRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+// 展开职业模板
+func expandRoleSlots(roleSlots []MatchRoleSlot) []MatchRole {
+	slots := make([]MatchRole, 0)
+	for _, oneSlot := range roleSlots {
+		for i := int32(0); i < oneSlot.Num; i++ {
+			slots = append(slots, oneSlot.Role)
+		}
+	}
+	return slots
+}
+
+func (rma *RoleMatchAchieve) DoThreadMatch(base *MatchJobBase) {
+	slots := expandRoleSlots(base.QueMap.RoleSlots)
+	sideSize := base.QueMap.MatchSingleMax
+	if sideSize <= 0 {
+		sideSize = base.QueMap.MatchTotalNeed
+	}
+	if len(slots) <= 0 || int32(len(slots)) != sideSize || base.QueMap.MatchTotalNeed%sideSize != 0 {
+		xlog.Errorf("<queue_match> role match map=%d role slots not fit, slotNum=%d, sideSize=%d",
+			base.QueMap.MapID, len(slots), sideSize)
+		return
+	}
+
+	// 先来先匹配, 优先通道提前
+	elems := make([]*MatchElem, len(base.QueElems))
+	copy(elems, base.QueElems)
+	SortElemByOrder(elems)
+	// 每次凑一局, 用掉的去掉再凑下一局
+	used := make(map[*MatchElem]struct{})
+	for matchIdx := 0; matchIdx < base.MaxMatchNum; matchIdx++ {
+		sides := rma.matchOne(base, elems, used, slots, base.QueMap.MatchTotalNeed/sideSize)
+		if sides == nil {
+			return
+		}
+		// 输出结果
+		result := base.ResultAt(matchIdx)
+		result.RoleAssign = make(map[uint64]MatchRole)
+		for _, oneSide := range sides {
+			matchSide := newMatchSide()
+			for _, oneElem := range oneSide.elems {
+				matchSide.addElem(oneElem)
+				used[oneElem] = struct{}{}
+			}
+			for slotIdx, gamerIdx := range oneSide.slotUsed {
+				result.RoleAssign[oneSide.gamers[gamerIdx].GamerID] = oneSide.slots[slotIdx]
+			}
+			result.AddGroup(oneSide.elems...)
+			result.Sides = append(result.Sides, matchSide)
+		}
+	}
+}
+
+// 一局最多尝试多少次放入, 超过后放弃本局
+const roleSearchBudget = 20000
+
+// 职业凑局回溯搜索
+type roleMatchSearch struct {
+	base        *MatchJobBase
+	elems       []*MatchElem
+	datas       []*ScoreMatchElemData
+	suffixGamer []int32 // elems[i:]的总人数, 用于剪枝
+	sides       []*roleMatchSide
+	picked      []*MatchElem
+	budget      int
+}
+
+// 凑一局, 跳过used中的elem. 凑不满返回nil
+// 按先来先匹配的顺序回溯: 先尝试把elem放进某一组, 后面凑不满再换一组或者不选它.
+// 第一条搜索路径就是贪心的结果, 贪心能凑满时结果不变
+func (rma *RoleMatchAchieve) matchOne(base *MatchJobBase, elems []*MatchElem, used map[*MatchElem]struct{},
+	slots []MatchRole, sideNum int32) []*roleMatchSide {
+	search := &roleMatchSearch{
+		base:   base,
+		elems:  make([]*MatchElem, 0, len(elems)),
+		datas:  make([]*ScoreMatchElemData, 0, len(elems)),
+		sides:  make([]*roleMatchSide, sideNum),
+		picked: make([]*MatchElem, 0, len(slots)*int(sideNum)),
+		budget: roleSearchBudget,
+	}
+	for _, oneElem := range elems {
+		if _, ok := used[oneElem]; ok {
+			continue
+		}
+		data, ok := oneElem.ElemData.(*ScoreMatchElemData)
+		if !ok || data.GamerNum() <= 0 || data.GamerNum() > len(slots) {
+			continue
+		}
+		search.elems = append(search.elems, oneElem)
+		search.datas = append(search.datas, data)
+	}
+	search.suffixGamer = make([]int32, len(search.elems)+1)
+	for i := len(search.elems) - 1; i >= 0; i-- {
+		search.suffixGamer[i] = search.suffixGamer[i+1] + int32(search.datas[i].GamerNum())
+	}
+	for i := range search.sides {
+		search.sides[i] = newRoleMatchSide(slots)
+	}
+	if !search.fill(0, int32(len(slots))*sideNum) {
+		return nil
+	}
+	return search.sides
+}
+
+// 从elems[idx:]中凑满剩下的freeSlots个职业位
+func (rms *roleMatchSearch) fill(idx int, freeSlots int32) bool {
+	for ; idx < len(rms.elems); idx++ {
+		if freeSlots <= 0 {
+			return true
+		}
+		// 后面的人全选也凑不满
+		if rms.suffixGamer[idx] < freeSlots || rms.budget <= 0 {
+			return false
+		}
+		oneElem, data := rms.elems[idx], rms.datas[idx]
+		// 不能和已选中的匹配到一起
+		if !rms.base.CanJoin(oneElem, rms.picked) {
+			continue
+		}
+		triedEmpty := false
+		for _, oneSide := range rms.sides {
+			// 空的组都一样, 只试一个
+			if len(oneSide.gamers) == 0 {
+				if triedEmpty {
+					continue
+				}
+				triedEmpty = true
+			}
+			if oneSide.full() {
+				continue
+			}
+			rms.budget--
+			if rms.budget%256 == 0 && rms.base.Canceled() {
+				rms.budget = 0
+				return false
+			}
+			mark := oneSide.mark()
+			if !oneSide.tryAdd(oneElem, data) {
+				continue
+			}
+			rms.picked = append(rms.picked, oneElem)
+			if rms.fill(idx+1, freeSlots-int32(data.GamerNum())) {
+				return true
+			}
+			rms.picked = rms.picked[:len(rms.picked)-1]
+			oneSide.reset(mark)
+		}
+		// 不选这个elem, 继续往后
+	}
+	return freeSlots <= 0
+}
+
+func (rma *RoleMatchAchieve) CreateNewSelf() IMatchAchieve {
+	newSelf := *rma
+	return &newSelf
+}
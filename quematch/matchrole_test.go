@@ -0,0 +1,85 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 按offset依次进队, 保证先来先匹配的顺序
+func testTraceInOrder(strategy uint32, elems ...*MatchElem) []MatchArrival {
+	trace := make([]MatchArrival, 0, len(elems))
+	for i, oneElem := range elems {
+		trace = append(trace, testTrace(strategy, time.Duration(i)*100*time.Millisecond, oneElem)...)
+	}
+	return trace
+}
+
+func testRoleMap() MapInfo {
+	return MapInfo{
+		MatchTotalNeed: 4,
+		MatchSingleMax: 2,
+		RoleSlots:      []MatchRoleSlot{{Role: 1, Num: 1}, {Role: 2, Num: 1}},
+	}
+}
+
+func TestRoleMatchBacktracksAcrossSides(t *testing.T) {
+	// 贪心会把1、2放进同一组, 剩下两个只会职业1的凑不成另一组
+	trace := testTraceInOrder(MatchStrategyRole,
+		newTestRoleElem(1, 1000, 1, 2),
+		newTestRoleElem(2, 1000, 1, 2),
+		newTestRoleElem(3, 1000, 1),
+		newTestRoleElem(4, 1000, 1),
+	)
+	results := simMatch(t, testRoleMap(), trace, 3*time.Second, nil)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	result := results[0]
+	checkResultElems(t, result, 1, 2, 3, 4)
+	if len(result.Sides) != 2 {
+		t.Fatalf("got %d sides, want 2", len(result.Sides))
+	}
+	want := map[uint64]MatchRole{10: 2, 20: 2, 30: 1, 40: 1}
+	for gamerID, role := range want {
+		if result.RoleAssign[gamerID] != role {
+			t.Fatalf("gamer %d role=%d, want %d, assign=%v", gamerID, result.RoleAssign[gamerID], role, result.RoleAssign)
+		}
+	}
+	for _, oneSide := range result.Sides {
+		roles := make(map[MatchRole]int)
+		for _, oneElem := range oneSide.Elems {
+			roles[result.RoleAssign[oneElem.ElemKey.ElemID*10]]++
+		}
+		if roles[1] != 1 || roles[2] != 1 {
+			t.Fatalf("side roles=%v, want one of each", roles)
+		}
+	}
+}
+
+func TestRoleMatchKeepsFirstComeOrder(t *testing.T) {
+	// 能凑满时不跳过先来的
+	trace := testTraceInOrder(MatchStrategyRole,
+		newTestRoleElem(1, 1000, 1),
+		newTestRoleElem(2, 1000, 2),
+		newTestRoleElem(3, 1000, 1),
+		newTestRoleElem(4, 1000, 1),
+		newTestRoleElem(5, 1000, 2),
+	)
+	results := simMatch(t, testRoleMap(), trace, 3*time.Second, nil)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkResultElems(t, results[0], 1, 2, 3, 5)
+}
+
+func TestRoleMatchUnfillable(t *testing.T) {
+	trace := testTraceInOrder(MatchStrategyRole,
+		newTestRoleElem(1, 1000, 1),
+		newTestRoleElem(2, 1000, 1),
+		newTestRoleElem(3, 1000, 1),
+		newTestRoleElem(4, 1000, 2),
+	)
+	if results := simMatch(t, testRoleMap(), trace, 3*time.Second, nil); len(results) != 0 {
+		t.Fatalf("matched unfillable queue: %v", resultElemIDs(results[0]))
+	}
+}
@@ -0,0 +1,75 @@
+package quematch
+
+import (
+	"sort"
+)
+
+/*
+	matchsched.go: 地图/队列调度
+	每次匹配前按队列长度、最久等待时间和地图权重给所有队列排优先级, 避免某些地图一直匹配不到
+*/
+
+// 一个待调度的队列
+type queueSchedItem struct {
+	queKey    MatchQueueKey
+	mapInfo   MapInfo
+	hasSupply bool  // 有增补请求时优先
+	priority  int64 // 优先级, 越大越先匹配
+}
+
+// 地图权重, 没配置按1处理
+func (mi *MapInfo) schedWeight() int64 {
+	if mi.Weight <= 0 {
+		return 1
+	}
+	return int64(mi.Weight)
+}
+
+// 按MapID排序的所有地图
+func (mqm *MatchQueueMgr) sortedMapsInfo() []MapInfo {
+	maps := make([]MapInfo, 0, len(mqm.mapsInfo))
+	for _, aMap := range mqm.mapsInfo {
+		maps = append(maps, aMap)
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		return maps[i].MapID < maps[j].MapID
+	})
+	return maps
+}
+
+// 计算队列优先级: 地图权重 * (队列长度 * 长度权重 + 最久等待秒数 * 等待权重)
+func (mqm *MatchQueueMgr) queuePriority(matchQue *matchQueue, mapInfo *MapInfo) int64 {
+	var oldestWait int64
+	if oldest := matchQue.matchElems.front(); oldest != nil {
+		oldestWait = oldest.WaitSecond()
+	}
+	score := int64(matchQue.getElemLen())*mqm.baseCfg.SchedLenWeight + oldestWait*mqm.baseCfg.SchedWaitWeight
+	return mapInfo.schedWeight() * score
+}
+
+// 返回本次需要匹配的队列, 按优先级从高到低
+func (mqm *MatchQueueMgr) scheduleQueues() []queueSchedItem {
+	items := make([]queueSchedItem, 0, len(mqm.waitingQueue))
+	for _, aMap := range mqm.sortedMapsInfo() {
+		queKeys := mqm.getMatchQueueKeyByMapID(aMap.MapID)
+		for _, queKey := range queKeys {
+			matchQue := mqm.findMatchQueue(queKey)
+			if matchQue == nil || matchQue.inMatch {
+				continue
+			}
+			items = append(items, queueSchedItem{
+				queKey:    queKey,
+				mapInfo:   aMap,
+				hasSupply: matchQue.hasSupply(),
+				priority:  mqm.queuePriority(matchQue, &aMap),
+			})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].hasSupply != items[j].hasSupply {
+			return items[i].hasSupply
+		}
+		return items[i].priority > items[j].priority
+	})
+	return items
+}
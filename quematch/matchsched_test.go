@@ -0,0 +1,85 @@
+package quematch
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+// 调度测试中的一个队列, 每个地图一个队列
+type testSchedQueue struct {
+	mapID   uint32
+	weight  int32
+	elemNum int
+	wait    int64 // 最久等待秒数
+	supply  bool
+}
+
+// 优先级 = 地图权重 * (长度 * 长度权重 + 最久等待 * 等待权重), 有增补的排最前, 相同时按MapID
+func TestScheduleQueuesOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		lenWeight  int64
+		waitWeight int64
+		queues     []testSchedQueue
+		order      []uint32 // 调度顺序的MapID
+		priority   []int64
+	}{
+		{"map weight", 1, 1, []testSchedQueue{
+			{mapID: 1, elemNum: 3},
+			{mapID: 2, elemNum: 1, wait: 10},
+			{mapID: 3, weight: 3, elemNum: 2, wait: 5},
+		}, []uint32{3, 2, 1}, []int64{21, 11, 3}},
+		{"length weight", 10, 1, []testSchedQueue{
+			{mapID: 1, elemNum: 3},
+			{mapID: 2, elemNum: 1, wait: 10},
+		}, []uint32{1, 2}, []int64{30, 20}},
+		{"wait weight", 1, 5, []testSchedQueue{
+			{mapID: 1, elemNum: 3, wait: 1},
+			{mapID: 2, elemNum: 1, wait: 2},
+		}, []uint32{2, 1}, []int64{11, 8}},
+		{"supply first", 1, 1, []testSchedQueue{
+			{mapID: 1, elemNum: 5, wait: 20},
+			{mapID: 2, supply: true},
+			{mapID: 3, elemNum: 1, supply: true},
+		}, []uint32{3, 2, 1}, []int64{1, 0, 25}},
+		{"tie by map", 1, 1, []testSchedQueue{
+			{mapID: 2, elemNum: 2},
+			{mapID: 1, elemNum: 2},
+		}, []uint32{1, 2}, []int64{2, 2}},
+	}
+	now := testStart.Add(100 * time.Second)
+	for _, tt := range tests {
+		mqm := NewMatchQueueMgr(nil)
+		clock := NewVirtualClock(testStart)
+		mqm.SetMatchClock(clock)
+		mqm.SetMatchBaseCfg(MatchBaseCfg{SchedLenWeight: tt.lenWeight, SchedWaitWeight: tt.waitWeight})
+		// 时钟不能往回走, 等待久的先进队
+		queues := append([]testSchedQueue(nil), tt.queues...)
+		sort.SliceStable(queues, func(i, j int) bool {
+			return queues[i].wait > queues[j].wait
+		})
+		for _, oneQue := range queues {
+			mqm.UpdateMatchMap(MapInfo{MapID: oneQue.mapID, Weight: oneQue.weight})
+			queKey := MatchQueueKey{MapID: oneQue.mapID, MatchStrategy: MatchStrategyNormal}
+			clock.Set(now.Add(-time.Duration(oneQue.wait) * time.Second))
+			for i := 0; i < oneQue.elemNum; i++ {
+				mqm.EnterWaitQueue(queKey, newTestElem(uint64(oneQue.mapID)*100+uint64(i), 0))
+			}
+			if oneQue.supply {
+				mqm.AddSubWorldSupply(queKey, &SupplyInfo{SupplyUUID: uint64(oneQue.mapID)})
+			}
+		}
+		clock.Set(now)
+		items := mqm.scheduleQueues()
+		if len(items) != len(tt.order) {
+			t.Fatalf("%s: got %d queues, want %d", tt.name, len(items), len(tt.order))
+		}
+		for i, item := range items {
+			if item.queKey.MapID != tt.order[i] || item.priority != tt.priority[i] {
+				t.Fatalf("%s: idx=%d got map %d priority %d, want map %d priority %d",
+					tt.name, i, item.queKey.MapID, item.priority, tt.order[i], tt.priority[i])
+			}
+		}
+	}
+}
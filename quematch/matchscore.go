@@ -0,0 +1,186 @@
+package quematch
+
+import (
+	"sort"
+)
+
+/*
+	matchscore.go: 内置分数匹配算法(MMR/ELO)
+	按单元平均分排序, 在排好序的队列中找出凑满MatchTotalNeed人且分差最小的一组
+*/
+
+// 分数匹配算法
+type ScoreMatchAchieve struct {
+	Expand *ScoreExpandCfg // 分差容忍度随等待时间扩展, nil表示不限制分差
+}
+
+// 参与分数匹配的单元
+type scoreMatchCand struct {
+	elem     *MatchElem
+	gamerNum int32
+	avgScore int32
+	minScore int32
+	maxScore int32
+}
+
+// 一次凑组尝试
+type scoreMatchTry struct {
+	picked []int
+	spread int32
+}
+
+func newScoreMatchCand(elem *MatchElem) *scoreMatchCand {
+	data, ok := elem.ElemData.(*ScoreMatchElemData)
+	if !ok {
+		return nil
+	}
+	cand := &scoreMatchCand{
+		elem:     elem,
+		gamerNum: int32(data.GamerNum()),
+		avgScore: data.AvgScore(),
+	}
+	cand.minScore, cand.maxScore = data.scoreRange()
+	return cand
+}
+
+// 按平均分从低到高排序, 同分时先来的(含优先通道提前)在前
+func sortScoreMatchCand(cands []*scoreMatchCand) {
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].avgScore != cands[j].avgScore {
+			return cands[i].avgScore < cands[j].avgScore
+		}
+		return cands[i].elem.OrderTime().Before(cands[j].elem.OrderTime())
+	})
+}
+
+// 从startIdx开始往后凑满need人, 返回选中的下标和分差
+func (sma *ScoreMatchAchieve) fillFrom(base *MatchJobBase, cands []*scoreMatchCand, startIdx int,
+	need int32) ([]int, int32) {
+	picked := make([]int, 0, need)
+	pickedElems := make([]*MatchElem, 0, need)
+	var minScore, maxScore int32
+	for i := startIdx; i < len(cands) && need > 0; i++ {
+		if cands[i].gamerNum <= 0 || cands[i].gamerNum > need {
+			continue
+		}
+		// 不能和已选中的匹配到一起
+		if !base.CanJoin(cands[i].elem, pickedElems) {
+			continue
+		}
+		// 分数范围从第一个选中的开始算, 跳过的不算
+		if len(picked) <= 0 {
+			minScore, maxScore = cands[i].minScore, cands[i].maxScore
+		}
+		picked = append(picked, i)
+		pickedElems = append(pickedElems, cands[i].elem)
+		need -= cands[i].gamerNum
+		if cands[i].minScore < minScore {
+			minScore = cands[i].minScore
+		}
+		if cands[i].maxScore > maxScore {
+			maxScore = cands[i].maxScore
+		}
+	}
+	if need != 0 {
+		return nil, 0
+	}
+	spread := maxScore - minScore
+	if !sma.spreadAccept(cands, picked, spread) {
+		return nil, 0
+	}
+	return picked, spread
+}
+
+// 分差是否可以接受. 以组内等待最久的元素的容忍度为准
+func (sma *ScoreMatchAchieve) spreadAccept(cands []*scoreMatchCand, picked []int, spread int32) bool {
+	if sma.Expand == nil {
+		return true
+	}
+	var maxWait int64
+	for _, idx := range picked {
+		if wait := cands[idx].elem.WaitSecond(); wait > maxWait {
+			maxWait = wait
+		}
+	}
+	return spread <= sma.Expand.Range(maxWait)
+}
+
+func (sma *ScoreMatchAchieve) DoThreadMatch(base *MatchJobBase) {
+	need := base.QueMap.MatchTotalNeed
+	if need <= 0 {
+		return
+	}
+	cands := make([]*scoreMatchCand, 0, len(base.QueElems))
+	for i := 0; i < len(base.QueElems); i++ {
+		if cand := newScoreMatchCand(base.QueElems[i]); cand != nil {
+			cands = append(cands, cand)
+		}
+	}
+	sortScoreMatchCand(cands)
+
+	// 每次凑一局, 用掉的去掉再凑下一局
+	for matchIdx := 0; matchIdx < base.MaxMatchNum; matchIdx++ {
+		elems, sides := sma.matchOne(base, cands, need)
+		if elems == nil {
+			return
+		}
+		result := base.ResultAt(matchIdx)
+		result.AddGroup(elems...)
+		result.Sides = sides
+		picked := make(map[*MatchElem]struct{}, len(elems))
+		for _, oneElem := range elems {
+			picked[oneElem] = struct{}{}
+		}
+		left := cands[:0:0]
+		for _, cand := range cands {
+			if _, ok := picked[cand.elem]; !ok {
+				left = append(left, cand)
+			}
+		}
+		cands = left
+	}
+}
+
+// 凑一局. 返回选中的elem和分边
+func (sma *ScoreMatchAchieve) matchOne(base *MatchJobBase, cands []*scoreMatchCand,
+	need int32) ([]*MatchElem, []*MatchSide) {
+	// 逐个起点尝试, 按分差从小到大挑第一组能成局的
+	tries := make([]scoreMatchTry, 0, len(cands))
+	for startIdx := 0; startIdx < len(cands); startIdx++ {
+		if base.Canceled() {
+			return nil, nil
+		}
+		picked, spread := sma.fillFrom(base, cands, startIdx, need)
+		if picked == nil {
+			continue
+		}
+		tries = append(tries, scoreMatchTry{picked: picked, spread: spread})
+	}
+	sort.SliceStable(tries, func(i, j int) bool {
+		return tries[i].spread < tries[j].spread
+	})
+	for _, oneTry := range tries {
+		elems := make([]*MatchElem, 0, len(oneTry.picked))
+		for _, idx := range oneTry.picked {
+			elems = append(elems, cands[idx].elem)
+		}
+		// 两边对战时需要能按组队拆成人数相等的两边
+		var sides []*MatchSide
+		if base.QueMap.MatchSingleMax > 0 && base.QueMap.MatchSingleMax*2 == need {
+			if sides = BalanceTwoSides(elems, base.QueMap.MatchSingleMax); sides == nil {
+				continue
+			}
+		}
+		return elems, sides
+	}
+	return nil, nil
+}
+
+func (sma *ScoreMatchAchieve) CreateNewSelf() IMatchAchieve {
+	newSelf := *sma
+	if sma.Expand != nil {
+		expand := *sma.Expand
+		newSelf.Expand = &expand
+	}
+	return &newSelf
+}
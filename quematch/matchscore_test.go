@@ -0,0 +1,61 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScoreMatchPicksClosestGroup(t *testing.T) {
+	trace := testTrace(MatchStrategyScore, 0,
+		newTestElem(1, 1000),
+		newTestElem(2, 1800),
+		newTestElem(3, 1010),
+		newTestElem(4, 1500),
+		newTestElem(5, 990),
+		newTestElem(6, 1020),
+	)
+	results := simMatch(t, MapInfo{MatchTotalNeed: 4}, trace, 3*time.Second, nil)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkResultElems(t, results[0], 1, 3, 5, 6)
+}
+
+func TestScoreMatchExpandWaitsForRange(t *testing.T) {
+	trace := testTrace(MatchStrategyScore, 0,
+		newTestElem(1, 1000),
+		newTestElem(2, 1300),
+	)
+	setup := func(coll *MatchDataCollector) {
+		coll.RegisterMatchAchieve(MatchStrategyScore, &ScoreMatchAchieve{
+			Expand: &ScoreExpandCfg{Curve: ExpandCurveStep, BaseRange: 100, StepRange: 100, StepSecond: 5},
+		})
+	}
+	// 等满10秒允许分差300
+	if results := simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 9*time.Second, setup); len(results) != 0 {
+		t.Fatalf("matched before range expanded: %v", resultElemIDs(results[0]))
+	}
+	trace = testTrace(MatchStrategyScore, 0, newTestElem(1, 1000), newTestElem(2, 1300))
+	results := simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 12*time.Second, setup)
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	checkResultElems(t, results[0], 1, 2)
+}
+
+func TestScoreFillSkipsOversizedStart(t *testing.T) {
+	// 起点是3人组队, 凑2人时被跳过, 分差不能算上它
+	cands := []*scoreMatchCand{
+		newScoreMatchCand(newTestElem(1, 500, 1000, 1500)),
+		newScoreMatchCand(newTestElem(2, 1000)),
+		newScoreMatchCand(newTestElem(3, 1020)),
+	}
+	sma := &ScoreMatchAchieve{Expand: &ScoreExpandCfg{BaseRange: 50}}
+	picked, spread := sma.fillFrom(&MatchJobBase{}, cands, 0, 2)
+	if len(picked) != 2 || picked[0] != 1 || picked[1] != 2 {
+		t.Fatalf("picked=%v, want [1 2]", picked)
+	}
+	if spread != 20 {
+		t.Fatalf("spread=%d, want 20", spread)
+	}
+}
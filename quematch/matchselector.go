@@ -0,0 +1,154 @@
+package quematch
+
+import (
+	"sort"
+)
+
+/*
+	matchselector.go: client服务器选择策略
+	tryMatchOnce每次按策略返回的顺序把匹配分给client服务器
+*/
+
+// 可选的client服务器
+type ClientCandidate struct {
+	Key  ClientKey
+	Load ClientInfo
+}
+
+// client服务器选择接口. cands已按ServerID排好序, 返回本次尝试的client顺序
+type IClientSelector interface {
+	SelectClients(cands []ClientCandidate) []ClientCandidate
+}
+
+// 按ServerID排序, 保证相同输入得到相同结果
+func sortCandidateByKey(cands []ClientCandidate) {
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].Key.ServerID < cands[j].Key.ServerID
+	})
+}
+
+// 最空闲优先(默认)
+type LeastLoadedSelector struct {
+}
+
+func (s *LeastLoadedSelector) SelectClients(cands []ClientCandidate) []ClientCandidate {
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].Load.hungry() > cands[j].Load.hungry()
+	})
+	return cands
+}
+
+// 装满优先. 尽量先把一台client服务器填满
+type BinPackSelector struct {
+}
+
+func (s *BinPackSelector) SelectClients(cands []ClientCandidate) []ClientCandidate {
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].Load.hungry() < cands[j].Load.hungry()
+	})
+	return cands
+}
+
+// 平滑加权轮询
+type WeightedRoundRobinSelector struct {
+	Weights map[ClientKey]int32 // 权重, 没配置的按1处理
+	current map[ClientKey]int32 // 当前权重
+}
+
+func NewWeightedRoundRobinSelector(weights map[ClientKey]int32) *WeightedRoundRobinSelector {
+	return &WeightedRoundRobinSelector{
+		Weights: weights,
+		current: make(map[ClientKey]int32),
+	}
+}
+
+func (s *WeightedRoundRobinSelector) weight(key ClientKey) int32 {
+	if w, ok := s.Weights[key]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (s *WeightedRoundRobinSelector) SelectClients(cands []ClientCandidate) []ClientCandidate {
+	if s.current == nil {
+		s.current = make(map[ClientKey]int32)
+	}
+	var totalWeight int32
+	bestIdx := -1
+	for i := range cands {
+		w := s.weight(cands[i].Key)
+		totalWeight += w
+		s.current[cands[i].Key] += w
+		if bestIdx < 0 || s.current[cands[i].Key] > s.current[cands[bestIdx].Key] {
+			bestIdx = i
+		}
+	}
+	if bestIdx < 0 {
+		return cands
+	}
+	bestKey := cands[bestIdx].Key
+	s.current[bestKey] -= totalWeight
+	// 选中的放最前, 其余按当前权重排在后面作为备选
+	sort.SliceStable(cands, func(i, j int) bool {
+		return s.current[cands[i].Key] > s.current[cands[j].Key]
+	})
+	return moveCandidateFront(cands, bestKey)
+}
+
+// 区域粘滞. 优先用上次选中的区域, 区域内按Next排序.
+// 上次的区域里没有空位多于MinHungry的client时不再粘滞, 换到Next排序后第一个有空位的区域
+type RegionStickySelector struct {
+	Next       IClientSelector // 区域内排序, nil按最空闲优先
+	MinHungry  int32           // 区域内至少要有一台client空位多于该值, 一般配成最大的MatchTotalNeed
+	lastRegion uint32
+	hasLast    bool
+}
+
+// 区域内是否还有空位足够的client
+func (s *RegionStickySelector) regionHungry(cands []ClientCandidate, region uint32) bool {
+	for i := range cands {
+		if cands[i].Load.Region == region && cands[i].Load.hungry() > s.MinHungry {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RegionStickySelector) SelectClients(cands []ClientCandidate) []ClientCandidate {
+	next := s.Next
+	if next == nil {
+		next = &LeastLoadedSelector{}
+	}
+	cands = next.SelectClients(cands)
+	if len(cands) <= 0 {
+		return cands
+	}
+	if s.hasLast && s.regionHungry(cands, s.lastRegion) {
+		sort.SliceStable(cands, func(i, j int) bool {
+			return cands[i].Load.Region == s.lastRegion && cands[j].Load.Region != s.lastRegion
+		})
+	}
+	s.hasLast = false
+	for i := range cands {
+		if cands[i].Load.hungry() > s.MinHungry {
+			s.lastRegion = cands[i].Load.Region
+			s.hasLast = true
+			break
+		}
+	}
+	return cands
+}
+
+// 把key对应的client移到最前
+func moveCandidateFront(cands []ClientCandidate, key ClientKey) []ClientCandidate {
+	for i := range cands {
+		if cands[i].Key != key {
+			continue
+		}
+		front := cands[i]
+		copy(cands[1:i+1], cands[:i])
+		cands[0] = front
+		break
+	}
+	return cands
+}
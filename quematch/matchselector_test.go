@@ -0,0 +1,66 @@
+package quematch
+
+import (
+	"testing"
+)
+
+func testCand(serverID uint32, region uint32, hungry int32) ClientCandidate {
+	return ClientCandidate{
+		Key:  ClientKey{ServerID: serverID},
+		Load: ClientInfo{MaxPlayerNum: hungry, Region: region},
+	}
+}
+
+// 上次的区域还有空位时一直用该区域
+func TestRegionStickyKeepsRegion(t *testing.T) {
+	s := &RegionStickySelector{MinHungry: 4}
+	cands := s.SelectClients([]ClientCandidate{testCand(1, 1, 10), testCand(2, 2, 8)})
+	if cands[0].Key.ServerID != 1 {
+		t.Fatalf("first pick=%d, want 1", cands[0].Key.ServerID)
+	}
+	// 区域2更空闲, 但区域1还放得下
+	cands = s.SelectClients([]ClientCandidate{testCand(1, 1, 6), testCand(2, 2, 8)})
+	if cands[0].Key.ServerID != 1 {
+		t.Fatalf("sticky pick=%d, want 1", cands[0].Key.ServerID)
+	}
+}
+
+// 上次的区域放不下一局时换区域, 之后粘滞到新区域
+func TestRegionStickyRotatesWhenFull(t *testing.T) {
+	s := &RegionStickySelector{MinHungry: 4}
+	s.SelectClients([]ClientCandidate{testCand(1, 1, 10), testCand(2, 2, 8)})
+	cands := s.SelectClients([]ClientCandidate{testCand(1, 1, 4), testCand(2, 2, 8)})
+	if cands[0].Key.ServerID != 2 {
+		t.Fatalf("rotate pick=%d, want 2", cands[0].Key.ServerID)
+	}
+	// 区域1空出来后仍然粘在区域2
+	cands = s.SelectClients([]ClientCandidate{testCand(1, 1, 10), testCand(2, 2, 6)})
+	if cands[0].Key.ServerID != 2 {
+		t.Fatalf("sticky pick=%d, want 2", cands[0].Key.ServerID)
+	}
+}
+
+// client空位正好等于需求人数时不分配
+func TestPickClientNeedsMoreThanTotal(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	mapInfo := MapInfo{MapID: testMapID, MatchTotalNeed: 4, MatchSingleMax: 2}
+	cliKey := ClientKey{ServerID: 1}
+	cliInfo := mqm.GetMatchClientInfo(cliKey)
+	cliInfo.MaxPlayerNum = 4
+	item := &queueSchedItem{queKey: MatchQueueKey{MapID: testMapID}, mapInfo: mapInfo}
+	cands := []ClientCandidate{{Key: cliKey, Load: *cliInfo}}
+	if mqm.pickQueueClient(item, cands) != nil {
+		t.Fatal("pickQueueClient accepted a client with hungry == need")
+	}
+	if mqm.pickResultClient(&MatchResult{}, &mapInfo) != nil {
+		t.Fatal("pickResultClient accepted a client with hungry == need")
+	}
+	cliInfo.MaxPlayerNum = 5
+	cands[0].Load = *cliInfo
+	if mqm.pickQueueClient(item, cands) == nil {
+		t.Fatal("pickQueueClient rejected a client with hungry > need")
+	}
+	if mqm.pickResultClient(&MatchResult{}, &mapInfo) == nil {
+		t.Fatal("pickResultClient rejected a client with hungry > need")
+	}
+}
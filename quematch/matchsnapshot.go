@@ -0,0 +1,272 @@
+package quematch
+
+import (
+	"github.com/json-iterator/go"
+	"github.com/qixi7/xengine_core/xlog"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+/*
+	matchsnapshot.go: 匹配队列快照. Destroy时写本地文件, Init时恢复, 保留StartTime保证重启后等待时间公平
+*/
+
+// 快照编解码(业务实现). 业务自定义数据和回调无法直接序列化, 由业务负责
+type IMatchSnapshotCodec interface {
+	EncodeGamerData(data IScoreMatchGamerExt) ([]byte, error)
+	DecodeGamerData(buf []byte) (IScoreMatchGamerExt, error)
+	EncodeSupplyData(data interface{}) ([]byte, error)
+	DecodeSupplyData(buf []byte) (interface{}, error)
+	RestoreElemFunc(queKey MatchQueueKey, elemKey MatchElemKey) IElemFunc
+}
+
+type gamerSnapshot struct {
+	GamerID   uint64
+	Score     int32
+	Roles     []MatchRole
+	Latency   map[uint32]int32
+	GamerData []byte
+}
+
+type elemSnapshot struct {
+	ElemKey    MatchElemKey
+	StartTime  time.Time
+	Lane       MatchLane
+	Priority   int32
+	Gamers     []gamerSnapshot
+	TicketKeys []MatchQueueKey // 多队列时所有队列Key
+}
+
+type supplySnapshot struct {
+	SupplyUUID uint64
+	InfoData   []byte
+}
+
+type queueSnapshot struct {
+	QueKey   MatchQueueKey
+	Elems    []elemSnapshot
+	Supplies []supplySnapshot
+}
+
+type clientSnapshot struct {
+	Key    ClientKey
+	Load   ClientInfo
+	NotUse bool
+}
+
+type matchSnapshot struct {
+	SaveTime time.Time
+	Queues   []queueSnapshot
+	Clients  []clientSnapshot
+}
+
+// 设置快照编解码, 必须在Init之前设置才能恢复
+func (mqm *MatchQueueMgr) SetSnapshotCodec(codec IMatchSnapshotCodec) {
+	mqm.snapshotCodec = codec
+}
+
+func (mqm *MatchQueueMgr) encodeElem(elem *MatchElem) (elemSnapshot, bool) {
+	elemSnap := elemSnapshot{
+		ElemKey:   elem.ElemKey,
+		StartTime: elem.StartTime,
+		Lane:      elem.Lane,
+		Priority:  elem.Priority,
+	}
+	data, ok := elem.ElemData.(*ScoreMatchElemData)
+	if !ok {
+		xlog.Errorf("<queue_match> snapshot unsupported elem data type=%T, elem=%v", elem.ElemData, elem.ElemKey)
+		return elemSnap, false
+	}
+	elemSnap.Gamers = make([]gamerSnapshot, 0, len(data.Gamers))
+	for _, oneGamer := range data.Gamers {
+		gamerSnap := gamerSnapshot{
+			GamerID: oneGamer.GamerID,
+			Score:   oneGamer.Score,
+			Roles:   oneGamer.Roles,
+			Latency: oneGamer.Latency,
+		}
+		if oneGamer.GamerData != nil {
+			buf, err := mqm.snapshotCodec.EncodeGamerData(oneGamer.GamerData)
+			if err != nil {
+				xlog.Errorf("<queue_match> snapshot encode gamer=%d err=%v", oneGamer.GamerID, err)
+				return elemSnap, false
+			}
+			gamerSnap.GamerData = buf
+		}
+		elemSnap.Gamers = append(elemSnap.Gamers, gamerSnap)
+	}
+	return elemSnap, true
+}
+
+func (mqm *MatchQueueMgr) decodeElem(queKey MatchQueueKey, elemSnap *elemSnapshot) *MatchElem {
+	elemFunc := mqm.snapshotCodec.RestoreElemFunc(queKey, elemSnap.ElemKey)
+	if elemFunc == nil {
+		return nil
+	}
+	data := NewScoreMatchElemData()
+	for _, gamerSnap := range elemSnap.Gamers {
+		oneGamer := ScoreMatchGamer{
+			GamerID: gamerSnap.GamerID,
+			Score:   gamerSnap.Score,
+			Roles:   gamerSnap.Roles,
+			Latency: gamerSnap.Latency,
+		}
+		if len(gamerSnap.GamerData) > 0 {
+			gamerData, err := mqm.snapshotCodec.DecodeGamerData(gamerSnap.GamerData)
+			if err != nil {
+				xlog.Errorf("<queue_match> snapshot decode gamer=%d err=%v", gamerSnap.GamerID, err)
+				return nil
+			}
+			oneGamer.GamerData = gamerData
+		}
+		data.Gamers = append(data.Gamers, oneGamer)
+	}
+	elem := NewMatchElem(elemSnap.ElemKey, data, elemFunc)
+	elem.StartTime = elemSnap.StartTime
+	elem.Lane = elemSnap.Lane
+	elem.Priority = elemSnap.Priority
+	return elem
+}
+
+// 生成快照, saved记录保存了的elem
+func (mqm *MatchQueueMgr) buildSnapshot(saved map[MatchElemKey]struct{}) *matchSnapshot {
+	snap := &matchSnapshot{
+		SaveTime: mqm.now(),
+		Queues:   make([]queueSnapshot, 0, len(mqm.waitingQueue)),
+		Clients:  make([]clientSnapshot, 0, len(mqm.matchClientInfo)),
+	}
+	for queKey, oneQue := range mqm.waitingQueue {
+		queSnap := queueSnapshot{
+			QueKey:   queKey,
+			Elems:    make([]elemSnapshot, 0, oneQue.getElemLen()),
+			Supplies: make([]supplySnapshot, 0, len(oneQue.supplyInfos)),
+		}
+		oneQue.matchElems.foreach(func(oneElem *MatchElem) bool {
+			// 多队列elem只在主队列中保存一次
+			if mqm.elem2MatchQueue[oneElem.ElemKey] != queKey {
+				return true
+			}
+			elemSnap, ok := mqm.encodeElem(oneElem)
+			if !ok {
+				xlog.Errorf("<queue_match> snapshot skip elem=%v", oneElem.ElemKey)
+				return true
+			}
+			elemSnap.TicketKeys = mqm.elemTickets[oneElem.ElemKey]
+			queSnap.Elems = append(queSnap.Elems, elemSnap)
+			saved[oneElem.ElemKey] = struct{}{}
+			return true
+		})
+		for _, oneSupply := range oneQue.supplyInfos {
+			supSnap := supplySnapshot{SupplyUUID: oneSupply.SupplyUUID}
+			if oneSupply.InfoData != nil {
+				buf, err := mqm.snapshotCodec.EncodeSupplyData(oneSupply.InfoData)
+				if err != nil {
+					xlog.Errorf("<queue_match> snapshot encode supply=%d err=%v", oneSupply.SupplyUUID, err)
+					continue
+				}
+				supSnap.InfoData = buf
+			}
+			queSnap.Supplies = append(queSnap.Supplies, supSnap)
+		}
+		if len(queSnap.Elems) > 0 || len(queSnap.Supplies) > 0 {
+			snap.Queues = append(snap.Queues, queSnap)
+		}
+	}
+	for cliKey, cliInfo := range mqm.matchClientInfo {
+		snap.Clients = append(snap.Clients, clientSnapshot{
+			Key:    cliKey,
+			Load:   cliInfo.load,
+			NotUse: cliInfo.notUse,
+		})
+	}
+	return snap
+}
+
+// 保存快照到baseCfg.SnapshotPath
+func (mqm *MatchQueueMgr) SaveSnapshot() bool {
+	return mqm.saveSnapshot() != nil
+}
+
+// 保存快照, 返回保存了的elem. 没有开启或保存失败返回nil
+func (mqm *MatchQueueMgr) saveSnapshot() map[MatchElemKey]struct{} {
+	if mqm.baseCfg.SnapshotPath == "" || mqm.snapshotCodec == nil {
+		return nil
+	}
+	saved := make(map[MatchElemKey]struct{})
+	buf, err := jsoniter.Marshal(mqm.buildSnapshot(saved))
+	if err != nil {
+		xlog.Errorf("<queue_match> snapshot Marshal err=%v", err)
+		return nil
+	}
+	// 先写临时文件再改名, 避免写一半宕机
+	tmpPath := mqm.baseCfg.SnapshotPath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, buf, 0644); err != nil {
+		xlog.Errorf("<queue_match> snapshot WriteFile err=%v", err)
+		return nil
+	}
+	if err = os.Rename(tmpPath, mqm.baseCfg.SnapshotPath); err != nil {
+		xlog.Errorf("<queue_match> snapshot Rename err=%v", err)
+		return nil
+	}
+	xlog.InfoF("<queue_match> snapshot saved, path=%s, elemNum=%d", mqm.baseCfg.SnapshotPath, len(saved))
+	return saved
+}
+
+// 从baseCfg.SnapshotPath恢复快照, 恢复后删除快照文件避免重复恢复
+func (mqm *MatchQueueMgr) LoadSnapshot() bool {
+	if mqm.baseCfg.SnapshotPath == "" || mqm.snapshotCodec == nil {
+		return false
+	}
+	buf, err := ioutil.ReadFile(mqm.baseCfg.SnapshotPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			xlog.Errorf("<queue_match> snapshot ReadFile err=%v", err)
+		}
+		return false
+	}
+	snap := &matchSnapshot{}
+	if err = jsoniter.Unmarshal(buf, snap); err != nil {
+		xlog.Errorf("<queue_match> snapshot Unmarshal err=%v", err)
+		return false
+	}
+	for _, cliSnap := range snap.Clients {
+		mqm.matchClientInfo[cliSnap.Key] = &matchClient{
+			key:    cliSnap.Key,
+			load:   cliSnap.Load,
+			notUse: cliSnap.NotUse,
+		}
+	}
+	for i := range snap.Queues {
+		queSnap := &snap.Queues[i]
+		for j := range queSnap.Elems {
+			elem := mqm.decodeElem(queSnap.QueKey, &queSnap.Elems[j])
+			if elem == nil {
+				xlog.Errorf("<queue_match> snapshot restore elem=%v fail", queSnap.Elems[j].ElemKey)
+				continue
+			}
+			if findElem, _ := mqm.findMatchElem(elem.ElemKey); findElem != nil {
+				continue
+			}
+			mqm.push(queSnap.QueKey, elem)
+			mqm.pushTicketQueues(queSnap.Elems[j].TicketKeys, elem)
+		}
+		for _, supSnap := range queSnap.Supplies {
+			info := &SupplyInfo{SupplyUUID: supSnap.SupplyUUID}
+			if len(supSnap.InfoData) > 0 {
+				infoData, err := mqm.snapshotCodec.DecodeSupplyData(supSnap.InfoData)
+				if err != nil {
+					xlog.Errorf("<queue_match> snapshot decode supply=%d err=%v", supSnap.SupplyUUID, err)
+					continue
+				}
+				info.InfoData = infoData
+			}
+			mqm.AddSubWorldSupply(queSnap.QueKey, info)
+		}
+	}
+	if err = os.Remove(mqm.baseCfg.SnapshotPath); err != nil {
+		xlog.Errorf("<queue_match> snapshot Remove err=%v", err)
+	}
+	xlog.InfoF("<queue_match> snapshot restored, path=%s, saveTime=%v", mqm.baseCfg.SnapshotPath, snap.SaveTime)
+	return true
+}
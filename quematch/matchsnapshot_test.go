@@ -0,0 +1,84 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xmodule"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试用快照编解码, 没有业务数据
+type testSnapshotCodec struct {
+}
+
+func (c *testSnapshotCodec) EncodeGamerData(IScoreMatchGamerExt) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *testSnapshotCodec) DecodeGamerData([]byte) (IScoreMatchGamerExt, error) {
+	return nil, nil
+}
+
+func (c *testSnapshotCodec) EncodeSupplyData(interface{}) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *testSnapshotCodec) DecodeSupplyData([]byte) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *testSnapshotCodec) RestoreElemFunc(MatchQueueKey, MatchElemKey) IElemFunc {
+	return &testElemFunc{}
+}
+
+func newSnapshotMgr(path string) *MatchQueueMgr {
+	mqm := NewMatchQueueMgr(nil)
+	mqm.SetMatchBaseCfg(MatchBaseCfg{SnapshotPath: path})
+	mqm.SetSnapshotCodec(&testSnapshotCodec{})
+	return mqm
+}
+
+func TestSnapshotSuspendAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quematch.snap")
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyScore}
+	mqm := newSnapshotMgr(path)
+	mqm.Init(xmodule.DModuleGetter{})
+	solo := newTestElem(1, 1000)
+	team := newTestElem(2, 1100, 1200)
+	// 进队时间按mgr的时钟
+	startTime := time.Unix(1000, 0)
+	mqm.SetMatchClock(NewVirtualClock(startTime))
+	mqm.EnterWaitQueue(queKey, solo)
+	mqm.EnterWaitQueue(queKey, team)
+	mqm.Destroy()
+	for _, oneElem := range []*MatchElem{solo, team} {
+		leaves := elemLeaves(oneElem)
+		if len(leaves) != 1 || leaves[0] != LeaveReasonSuspend {
+			t.Fatalf("elem=%v leaves=%v, want suspend", oneElem.ElemKey, leaves)
+		}
+	}
+
+	restored := newSnapshotMgr(path)
+	restored.Init(xmodule.DModuleGetter{})
+	elem, restoredKey := restored.FindMatchElem(solo.ElemKey)
+	if elem == nil || restoredKey != queKey {
+		t.Fatal("solo elem not restored")
+	}
+	if !elem.StartTime.Equal(startTime) {
+		t.Fatalf("StartTime=%v, want %v", elem.StartTime, startTime)
+	}
+	if elem, _ = restored.FindMatchElem(team.ElemKey); elem == nil || elem.ElemData.GamerNum() != 2 {
+		t.Fatal("team elem not restored")
+	}
+}
+
+func TestShutdownWithoutSnapshot(t *testing.T) {
+	mqm := NewMatchQueueMgr(nil)
+	mqm.Init(xmodule.DModuleGetter{})
+	solo := newTestElem(1, 1000)
+	mqm.EnterWaitQueue(MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyScore}, solo)
+	mqm.Destroy()
+	if leaves := elemLeaves(solo); len(leaves) != 1 || leaves[0] != LeaveReasonShutdown {
+		t.Fatalf("leaves=%v, want shutdown", leaves)
+	}
+}
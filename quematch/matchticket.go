@@ -0,0 +1,97 @@
+package quematch
+
+import (
+	"github.com/qixi7/xengine_core/xlog"
+)
+
+/*
+	matchticket.go: 多队列匹配
+	一个elem可以同时进多个队列(不同地图/策略), 任意一个队列匹配成功后从所有队列中删除
+	elem2MatchQueue只记录第一个(主)队列, 进出队回调也只按主队列调用一次
+*/
+
+// 同时进入多个匹配队列, queKeys[0]为主队列
+func (mqm *MatchQueueMgr) EnterWaitQueueMulti(queKeys []MatchQueueKey, elem *MatchElem) bool {
+	if elem == nil || len(queKeys) <= 0 {
+		return false
+	}
+	// 去重
+	uniqKeys := make([]MatchQueueKey, 0, len(queKeys))
+	keySet := make(map[MatchQueueKey]struct{}, len(queKeys))
+	for _, queKey := range queKeys {
+		if _, ok := keySet[queKey]; ok {
+			continue
+		}
+		keySet[queKey] = struct{}{}
+		uniqKeys = append(uniqKeys, queKey)
+	}
+	if !mqm.EnterWaitQueue(uniqKeys[0], elem) {
+		return false
+	}
+	mqm.pushTicketQueues(uniqKeys, elem)
+	return true
+}
+
+// 把已在主队列的elem加到其他队列. ticketKeys包含主队列
+func (mqm *MatchQueueMgr) pushTicketQueues(ticketKeys []MatchQueueKey, elem *MatchElem) {
+	if len(ticketKeys) <= 1 {
+		return
+	}
+	mainKey := mqm.elem2MatchQueue[elem.ElemKey]
+	for _, queKey := range ticketKeys {
+		if queKey == mainKey {
+			continue
+		}
+		mqm.getOrNewQueue(queKey).addMatch(elem)
+	}
+	mqm.elemTickets[elem.ElemKey] = ticketKeys
+	xlog.InfoF("<queue_match> enter ticket queues: keys=%v, elem=%v", ticketKeys, *elem)
+}
+
+// 从主队列以外的其他队列删除
+func (mqm *MatchQueueMgr) leaveTicketQueues(elemKey MatchElemKey) {
+	ticketKeys, ok := mqm.elemTickets[elemKey]
+	if !ok {
+		return
+	}
+	mainKey := mqm.elem2MatchQueue[elemKey]
+	for _, queKey := range ticketKeys {
+		if queKey == mainKey {
+			continue
+		}
+		if matchQue := mqm.findMatchQueue(queKey); matchQue != nil {
+			matchQue.delMatch(elemKey)
+		}
+	}
+	delete(mqm.elemTickets, elemKey)
+}
+
+// 从elem的多队列记录中去掉queKey, 只剩主队列时删掉记录
+func (mqm *MatchQueueMgr) removeTicketKey(elemKey MatchElemKey, queKey MatchQueueKey) {
+	ticketKeys, ok := mqm.elemTickets[elemKey]
+	if !ok {
+		return
+	}
+	leftKeys := make([]MatchQueueKey, 0, len(ticketKeys))
+	for _, oneKey := range ticketKeys {
+		if oneKey != queKey {
+			leftKeys = append(leftKeys, oneKey)
+		}
+	}
+	if len(leftKeys) <= 1 {
+		delete(mqm.elemTickets, elemKey)
+		return
+	}
+	mqm.elemTickets[elemKey] = leftKeys
+}
+
+// 获取elem所在的所有队列
+func (mqm *MatchQueueMgr) GetElemQueueKeys(elemKey MatchElemKey) []MatchQueueKey {
+	if ticketKeys, ok := mqm.elemTickets[elemKey]; ok {
+		return append([]MatchQueueKey(nil), ticketKeys...)
+	}
+	if queKey := mqm.findQueKeyByElemKey(elemKey); queKey != nil {
+		return []MatchQueueKey{*queKey}
+	}
+	return nil
+}
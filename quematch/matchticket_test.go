@@ -0,0 +1,93 @@
+package quematch
+
+import (
+	"testing"
+)
+
+var (
+	testTicketKeyA = MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyNormal}
+	testTicketKeyB = MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyScore}
+)
+
+// 新建模拟mgr, elems同时进A、B两个队列
+func newTicketColl(t *testing.T, collOK *testCollOK, elems ...*MatchElem) *MatchDataCollector {
+	t.Helper()
+	coll := newRepairColl(t, collOK, testTicketKeyA, 2)
+	for _, oneElem := range elems {
+		if !coll.GetMatchMgr().EnterWaitQueueMulti([]MatchQueueKey{testTicketKeyA, testTicketKeyB}, oneElem) {
+			t.Fatal("EnterWaitQueueMulti failed")
+		}
+	}
+	return coll
+}
+
+// 检查elem是否在队列中
+func checkInQueue(t *testing.T, mqm *MatchQueueMgr, queKey MatchQueueKey, id uint64, want bool) {
+	t.Helper()
+	in := false
+	if matchQue := mqm.findMatchQueue(queKey); matchQue != nil {
+		in = matchQue.findMatch(testElemKey(id)) != nil
+	}
+	if in != want {
+		t.Fatalf("elem %d in queue %v=%t, want %t", id, queKey, in, want)
+	}
+}
+
+// 离开匹配时从所有队列删除
+func TestMultiQueueLeaveAll(t *testing.T) {
+	coll := newTicketColl(t, &testCollOK{}, newTestElem(1, 0), newTestElem(2, 0))
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	checkInQueue(t, mqm, testTicketKeyB, 1, true)
+	mqm.LeaveQueue(testElemKey(1), LeaveReasonCancel)
+	checkInQueue(t, mqm, testTicketKeyA, 1, false)
+	checkInQueue(t, mqm, testTicketKeyB, 1, false)
+	if keys := mqm.GetElemQueueKeys(testElemKey(1)); len(keys) != 0 {
+		t.Fatalf("left elem queue keys=%v", keys)
+	}
+	checkInQueue(t, mqm, testTicketKeyB, 2, true)
+}
+
+// 在一个队列成局时从所有队列删除
+func TestMultiQueueMatchRemovesAll(t *testing.T) {
+	collOK := &testCollOK{}
+	elems := []*MatchElem{newTestElem(1, 0), newTestElem(2, 0), newTestElem(3, 0)}
+	coll := newTicketColl(t, collOK, elems...)
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	newTestMatchJob(mqm, testTicketKeyB, elems[:2]).DoReturn()
+	if len(collOK.results) != 1 {
+		t.Fatalf("got %d results, want 1", len(collOK.results))
+	}
+	for _, id := range []uint64{1, 2} {
+		checkInQueue(t, mqm, testTicketKeyA, id, false)
+		checkInQueue(t, mqm, testTicketKeyB, id, false)
+	}
+	checkInQueue(t, mqm, testTicketKeyA, 3, true)
+	checkInQueue(t, mqm, testTicketKeyB, 3, true)
+}
+
+// 删除主队列时多队列的elem留在剩下的队列, 只在该队列的elem离开
+func TestRemoveQueueMovesMultiElem(t *testing.T) {
+	coll := newTicketColl(t, &testCollOK{}, newTestElem(1, 0))
+	defer coll.EndSimulation()
+	mqm := coll.GetMatchMgr()
+	solo := newTestElem(2, 0)
+	mqm.EnterWaitQueue(testTicketKeyA, solo)
+	if !mqm.RemoveQueue(testTicketKeyA) {
+		t.Fatal("RemoveQueue failed")
+	}
+	checkLeaves(t, solo, LeaveReasonQueueRemove)
+	findElem, queKey := mqm.FindMatchElem(testElemKey(1))
+	if findElem == nil || queKey != testTicketKeyB {
+		t.Fatalf("multi elem main queue=%v, want %v", queKey, testTicketKeyB)
+	}
+	checkInQueue(t, mqm, testTicketKeyB, 1, true)
+	if keys := mqm.GetElemQueueKeys(testElemKey(1)); len(keys) != 1 || keys[0] != testTicketKeyB {
+		t.Fatalf("multi elem queue keys=%v, want [%v]", keys, testTicketKeyB)
+	}
+	checkLeaves(t, findElem)
+	// 之后离开不会残留
+	mqm.LeaveQueue(testElemKey(1), LeaveReasonCancel)
+	checkInQueue(t, mqm, testTicketKeyB, 1, false)
+}
@@ -5,25 +5,39 @@
 package quematch
 
 import (
+	"context"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/qixi7/xengine_core/xcontainer/job"
 	"github.com/qixi7/xengine_core/xlog"
 	"github.com/qixi7/xengine_core/xmetric"
 	"github.com/qixi7/xengine_core/xmodule"
+	"github.com/qixi7/xengine_pub/structimpl/dispatchcollector"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // 匹配基本配置信息
 type MatchBaseCfg struct {
-	MatchTickGap     int64 // 匹配帧数时间间隔, 一个队列一次匹配完之后才能进行下次匹配
-	ShowMatchTickGap int64 // 打印匹配信息log帧数间隔
+	MatchTickGap     int64  // 匹配帧数时间间隔, 一个队列一次匹配完之后才能进行下次匹配
+	ShowMatchTickGap int64  // 打印匹配信息log帧数间隔
+	MaxWaitSecond    int64  // 最长等待秒数, 超过后移出队列. 默认不限, <0表示改回不限
+	SnapshotPath     string // 队列快照文件路径, 为空表示不保存快照
+	SchedLenWeight   int64  // 调度时队列长度权重
+	SchedWaitWeight  int64  // 调度时最久等待秒数权重
+	MaxBoostSecond   int64  // 优先通道最多提前秒数, 默认300, <0表示不限
+	JobTimeoutMs     int64  // 匹配job超时毫秒数, 默认不限, <0表示改回不限
+	MaxMatchPerJob   int32  // 一次匹配job最多凑几局, <=0按1处理
 }
 
 // 匹配策略类型
 const (
 	MatchStrategyNone   = iota // 无效值
 	MatchStrategyNormal        // 常规匹配. 没有分数, 人够就行
+	MatchStrategyScore         // 分数匹配. 按MMR/ELO分差最小成组
+	MatchStrategyRole          // 职业匹配. 每组按职业模板凑满
 )
 
 // 匹配Key
@@ -39,14 +53,20 @@ type SupplyInfo struct {
 
 type matchQueue struct {
 	inMatch     bool
-	matchElems  []*MatchElem        // 匹配elem
+	matchElems  *elemQueue          // 匹配elem, 按进队顺序
 	supplyInfos []*SupplyInfo       // 增补请求队列
 	supplyMap   map[uint64]struct{} // 增补map
+	elemView    []*MatchElem        // 给匹配job的只读视图, 队列变化后重新生成
+
+	jobSeq      uint64             // 当前job序号
+	jobCancel   context.CancelFunc // 取消当前job
+	jobDeadline time.Time          // 当前job截止时间, 为零表示不限
+	jobSupply   *SupplyInfo        // 当前增补job的请求
 }
 
 func newMatchQueue() *matchQueue {
 	return &matchQueue{
-		matchElems:  make([]*MatchElem, 0),
+		matchElems:  newElemQueue(),
 		supplyInfos: make([]*SupplyInfo, 0),
 		supplyMap:   make(map[uint64]struct{}),
 	}
@@ -54,7 +74,7 @@ func newMatchQueue() *matchQueue {
 
 // 获取正常匹配元素数量
 func (mq *matchQueue) getElemLen() int {
-	return len(mq.matchElems)
+	return mq.matchElems.len()
 }
 
 // 获取增补匹配元素数量
@@ -62,17 +82,29 @@ func (mq *matchQueue) getSupplyLen() int {
 	return len(mq.supplyInfos)
 }
 
-func (mq *matchQueue) findMatchIdx(elemKey MatchElemKey) int {
-	for i := 0; i < len(mq.matchElems); i++ {
-		if mq.matchElems[i].ElemKey == elemKey {
-			return i
-		}
-	}
-	return -1
+func (mq *matchQueue) findMatch(elemKey MatchElemKey) *MatchElem {
+	return mq.matchElems.find(elemKey)
 }
 
 func (mq *matchQueue) addMatch(elem *MatchElem) {
-	mq.matchElems = append(mq.matchElems, elem)
+	if mq.matchElems.pushBack(elem) {
+		mq.elemView = nil
+	}
+}
+
+// 放回暂时移出的elem, 按进队时间回到原来的位置
+func (mq *matchQueue) restoreMatch(elem *MatchElem) {
+	if mq.matchElems.insertByStart(elem) {
+		mq.elemView = nil
+	}
+}
+
+func (mq *matchQueue) delMatch(elemKey MatchElemKey) *MatchElem {
+	elem := mq.matchElems.remove(elemKey)
+	if elem != nil {
+		mq.elemView = nil
+	}
+	return elem
 }
 
 func (mq *matchQueue) hasSupply() bool {
@@ -128,44 +160,108 @@ func (mq *matchQueue) delSupply(SupplyUUID uint64) bool {
 	return false
 }
 
-func (mq *matchQueue) copyCanMatchElems() []*MatchElem {
-	newGroup := make([]*MatchElem, 0, len(mq.matchElems))
-	for i := 0; i < len(mq.matchElems); i++ {
-		newGroup = append(newGroup, mq.matchElems[i].clone())
+// 给匹配job的只读视图. 队列没变化时直接复用, 变化后重新生成切片, 没被修改过的elem复用之前的副本.
+// 旧视图可能还在匹配线程中使用, 不能修改; job使用时要先复制一份切片
+func (mq *matchQueue) canMatchView() []*MatchElem {
+	if mq.elemView != nil {
+		return mq.elemView
+	}
+	view := make([]*MatchElem, 0, mq.getElemLen())
+	mq.matchElems.foreach(func(elem *MatchElem) bool {
+		elem.freeze()
+		view = append(view, elem.frozen)
+		return true
+	})
+	// 优先级高的在前
+	SortElemByOrder(view)
+	mq.elemView = view
+	return view
+}
+
+// 视图中的旧副本换成新副本, 排序会变时丢弃视图. job拿到的是视图的拷贝, 可以原地替换
+func (mq *matchQueue) replaceView(oldFrozen, newFrozen *MatchElem, sameOrder bool) {
+	if mq.elemView == nil {
+		return
+	}
+	if !sameOrder {
+		mq.elemView = nil
+		return
+	}
+	for i, oneElem := range mq.elemView {
+		if oneElem == oldFrozen {
+			mq.elemView[i] = newFrozen
+			return
+		}
 	}
-	return newGroup
 }
 
 // 匹配队列管理类
 type MatchQueueMgr struct {
-	baseCfg          MatchBaseCfg                   // 基本匹配配置
-	tickTotal        int64                          // tick总帧数
-	waitingQueue     map[MatchQueueKey]*matchQueue  // 不同matchKey对应的队列
-	elem2MatchQueue  map[MatchElemKey]MatchQueueKey // 通过elemKey查找匹配队列Key
-	matchClientInfo  map[ClientKey]*matchClient     // 匹配client key -> 匹配client info
-	mapsInfo         map[uint32]MapInfo             // mapID->map Info
-	jobCtrlGetter    xmodule.DModuleGetter          // job getter
-	selfGetter       xmodule.DModuleGetter          // 获取自己的getter
-	successDo        IMatchSuccess                  // 匹配成功回调(业务实现)
-	matchExtAchieve  map[uint32]IMatchAchieve       // 匹配算法(业务实现)
-	supplyExtAchieve map[uint32]ISupplyAchieve      // 增补算法(业务实现)
+	baseCfg          MatchBaseCfg                          // 基本匹配配置
+	tickTotal        int64                                 // tick总帧数
+	jobSeq           uint64                                // job序号
+	syncJob          bool                                  // 是否同步执行job, 模拟时使用
+	syncJobs         []job.Do                              // 待同步执行的job
+	waitingQueue     map[MatchQueueKey]*matchQueue         // 不同matchKey对应的队列
+	elem2MatchQueue  map[MatchElemKey]MatchQueueKey        // 通过elemKey查找匹配队列Key
+	elemTickets      map[MatchElemKey][]MatchQueueKey      // 同时在多个队列的elem -> 所有队列Key
+	matchClientInfo  map[ClientKey]*matchClient            // 匹配client key -> 匹配client info
+	mapsInfo         map[uint32]MapInfo                    // mapID->map Info
+	jobCtrlGetter    xmodule.DModuleGetter                 // job getter
+	selfGetter       xmodule.DModuleGetter                 // 获取自己的getter
+	successDo        IMatchSuccess                         // 匹配成功回调(业务实现)
+	matchExtAchieve  map[uint32]IMatchAchieve              // 匹配算法(业务实现)
+	supplyExtAchieve map[uint32]ISupplyAchieve             // 增补算法(业务实现)
+	queMaxWait       map[MatchQueueKey]int64               // 单个队列最长等待秒数, 优先于baseCfg, 0表示不限
+	queStats         map[MatchQueueKey]*queueStat          // 队列匹配统计, 用于估算等待时间
+	snapshotCodec    IMatchSnapshotCodec                   // 快照编解码(业务实现)
+	clientSelector   IClientSelector                       // client服务器选择策略
+	readyCollMgr     *dispatchcollector.DispatchCollectMgr // 匹配确认收集器, nil表示不确认
+	readyTimeout     time.Duration                         // 匹配确认超时
+	readyNotify      IMatchReadyNotify                     // 匹配确认通知(业务实现)
+	readyChecks      map[uint32]*readyCheck                // checkID -> 匹配确认
+	readyHeld        map[MatchElemKey]uint32               // 确认中的elem -> checkID
+	penalty          *matchPenalty                         // 违规惩罚记录
+	constraints      *matchConstraints                     // 匹配约束
+	latencyLimit     *LatencyExpandCfg                     // 允许延迟扩展配置, nil不限制
+	laneCfg          map[MatchLane]MatchLaneCfg            // 优先通道配置
+	clock            IMatchClock                           // 匹配时钟
 }
 
 // new
 func NewMatchQueueMgr(do IMatchSuccess) *MatchQueueMgr {
-	return &MatchQueueMgr{
+	mqm := &MatchQueueMgr{
 		baseCfg: MatchBaseCfg{
 			MatchTickGap:     10,
 			ShowMatchTickGap: 100,
+			SchedLenWeight:   1,
+			SchedWaitWeight:  1,
+			MaxBoostSecond:   300,
+			MaxMatchPerJob:   1,
 		},
 		successDo:        do,
 		waitingQueue:     make(map[MatchQueueKey]*matchQueue),
 		elem2MatchQueue:  make(map[MatchElemKey]MatchQueueKey),
+		elemTickets:      make(map[MatchElemKey][]MatchQueueKey),
 		matchClientInfo:  make(map[ClientKey]*matchClient),
 		matchExtAchieve:  make(map[uint32]IMatchAchieve),
 		supplyExtAchieve: make(map[uint32]ISupplyAchieve),
 		mapsInfo:         make(map[uint32]MapInfo),
-	}
+		queMaxWait:       make(map[MatchQueueKey]int64),
+		queStats:         make(map[MatchQueueKey]*queueStat),
+		clientSelector:   &LeastLoadedSelector{},
+		readyChecks:      make(map[uint32]*readyCheck),
+		readyHeld:        make(map[MatchElemKey]uint32),
+		penalty:          newMatchPenalty(),
+		constraints:      newMatchConstraints(),
+		laneCfg:          make(map[MatchLane]MatchLaneCfg),
+		clock:            realClock{},
+	}
+	// 内置匹配算法, 业务可以重新注册覆盖
+	mqm.matchExtAchieve[MatchStrategyNormal] = &NormalMatchAchieve{}
+	mqm.matchExtAchieve[MatchStrategyScore] = &ScoreMatchAchieve{}
+	mqm.matchExtAchieve[MatchStrategyRole] = &RoleMatchAchieve{}
+	return mqm
 }
 
 // 必须设置! 否则宕机失败
@@ -177,6 +273,14 @@ func (mqm *MatchQueueMgr) getJobController() *job.Controller {
 	return mqm.jobCtrlGetter.Get().(*job.Controller)
 }
 
+// 设置client服务器选择策略, nil恢复默认的最空闲优先
+func (mqm *MatchQueueMgr) SetClientSelector(selector IClientSelector) {
+	if selector == nil {
+		selector = &LeastLoadedSelector{}
+	}
+	mqm.clientSelector = selector
+}
+
 func (mqm *MatchQueueMgr) getMatchQueueKeyByMapID(mapID uint32) []MatchQueueKey {
 	keys := make([]MatchQueueKey, 0, 10)
 	for queKey := range mqm.waitingQueue {
@@ -188,6 +292,9 @@ func (mqm *MatchQueueMgr) getMatchQueueKeyByMapID(mapID uint32) []MatchQueueKey
 			}
 		}
 	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].MatchStrategy < keys[j].MatchStrategy
+	})
 	return keys
 }
 
@@ -207,25 +314,68 @@ func (mqm *MatchQueueMgr) findQueKeyByElemKey(elemKey MatchElemKey) *MatchQueueK
 	return &findSearch
 }
 
-// elem进对应的queue key匹配队列
-func (mqm *MatchQueueMgr) push(queKey MatchQueueKey, elem *MatchElem) {
+// 获取队列, 没有则新建
+func (mqm *MatchQueueMgr) getOrNewQueue(queKey MatchQueueKey) *matchQueue {
 	matchQue := mqm.findMatchQueue(queKey)
 	if matchQue == nil {
 		matchQue = newMatchQueue()
 		mqm.waitingQueue[queKey] = matchQue
 	}
+	return matchQue
+}
+
+// elem进对应的queue key匹配队列
+func (mqm *MatchQueueMgr) push(queKey MatchQueueKey, elem *MatchElem) {
+	matchQue := mqm.getOrNewQueue(queKey)
 	elemSearch := mqm.findQueKeyByElemKey(elem.ElemKey)
 	if elemSearch != nil {
 		panic("MatchElem in mut MatchQueue")
 	}
+	mqm.applyBoost(elem)
+	// 记下时钟, 之前进过队的丢掉旧副本
+	elem.clock = mqm.clock
+	elem.frozen = nil
 	matchQue.addMatch(elem)
 	mqm.elem2MatchQueue[elem.ElemKey] = queKey
 	elem.OnEnterQueue(queKey, elem)
 	xlog.InfoF("<queue_match> enter queue: key=%v, elem=%v", queKey, *elem)
 }
 
-// 匹配中是否存在该匹配元素
+// 匹配中是否存在该匹配元素. 返回的elem只能读, 要修改用UpdateMatchElem
 func (mqm *MatchQueueMgr) FindMatchElem(elemKey MatchElemKey) (*MatchElem, MatchQueueKey) {
+	return mqm.findMatchElem(elemKey)
+}
+
+// 修改匹配中的elem, 改完只重新生成该elem的只读副本, 下次匹配能看到. elem不在匹配中返回false
+func (mqm *MatchQueueMgr) UpdateMatchElem(elemKey MatchElemKey, updateFunc func(elem *MatchElem)) bool {
+	elem, _ := mqm.findMatchElem(elemKey)
+	if elem == nil {
+		return false
+	}
+	updateFunc(elem)
+	mqm.refreshFrozen(elem)
+	return true
+}
+
+// 重新生成elem的只读副本, 替换所在队列视图中的旧副本. 匹配线程还在用的旧副本不受影响
+func (mqm *MatchQueueMgr) refreshFrozen(elem *MatchElem) {
+	oldFrozen := elem.frozen
+	if oldFrozen == nil {
+		// 还没有副本的elem不在任何视图中, 生成视图时再生成副本
+		return
+	}
+	elem.frozen = nil
+	elem.freeze()
+	sameOrder := elem.OrderTime().Equal(oldFrozen.OrderTime())
+	for _, queKey := range mqm.GetElemQueueKeys(elem.ElemKey) {
+		if matchQue := mqm.findMatchQueue(queKey); matchQue != nil {
+			matchQue.replaceView(oldFrozen, elem.frozen, sameOrder)
+		}
+	}
+}
+
+// 查找匹配元素, 内部使用
+func (mqm *MatchQueueMgr) findMatchElem(elemKey MatchElemKey) (*MatchElem, MatchQueueKey) {
 	// 看是否在elem2MatchQueue中
 	queKey := mqm.findQueKeyByElemKey(elemKey)
 	if queKey == nil {
@@ -237,45 +387,68 @@ func (mqm *MatchQueueMgr) FindMatchElem(elemKey MatchElemKey) (*MatchElem, Match
 		return nil, *queKey
 	}
 	// 该matchQueue是否含有该elem
-	elemIdx := matchQue.findMatchIdx(elemKey)
-	if elemIdx < 0 {
+	elem := matchQue.findMatch(elemKey)
+	if elem == nil {
 		panic("elem search no elem")
 	}
-	return matchQue.matchElems[elemIdx], *queKey
+	return elem, *queKey
 }
 
-// EnterWaitQueue...
+// EnterWaitQueue... StartTime设为进队时间
 func (mqm *MatchQueueMgr) EnterWaitQueue(queKey MatchQueueKey, elem *MatchElem) bool {
 	if elem == nil {
 		return false
 	}
-	// 保险起见, 让这些elem key先离开匹配再进入匹配
+	// 有人在惩罚锁定中不能进入
+	if lockGamer, lockRemain := mqm.CheckElemPenalty(elem); lockRemain > 0 {
+		xlog.InfoF("<queue_match> enter queue locked: key=%v, gamer=%d, remain=%d",
+			queKey, lockGamer, lockRemain)
+		return false
+	}
+	// allKeys[0]为elem自己的key, 其余为组队成员的个人key
 	allKeys := elem.allTypeKey()
+	// 有人在匹配确认中不能进入, 先确认或者离开
+	for _, oneKey := range allKeys {
+		if checkID, ok := mqm.readyHeld[*oneKey]; ok {
+			xlog.InfoF("<queue_match> enter queue in ready check: key=%v, elemKey=%v, checkID=%d",
+				queKey, *oneKey, checkID)
+			return false
+		}
+	}
+	// 保险起见, 让这些elem key先离开匹配再进入匹配
 	for i := 0; i < len(allKeys); i++ {
-		mqm.LeaveQueue(*allKeys[i], false)
+		reason := LeaveReasonReenter
+		if i > 0 {
+			reason = LeaveReasonTeamRequeue
+		}
+		mqm.LeaveQueue(*allKeys[i], reason)
 	}
+	// 进队时间按mgr的时钟算
+	elem.StartTime = mqm.now()
 	mqm.push(queKey, elem)
 	return true
 }
 
 // LeaveQueue...
-func (mqm *MatchQueueMgr) LeaveQueue(elemKey MatchElemKey, success bool) bool {
+func (mqm *MatchQueueMgr) LeaveQueue(elemKey MatchElemKey, reason MatchLeaveReason) bool {
 	queKey := mqm.findQueKeyByElemKey(elemKey)
 	if queKey == nil {
-		return false
+		// 匹配确认中离开当作拒绝
+		return mqm.leaveReadyCheck(elemKey)
 	}
 	matchQue := mqm.findMatchQueue(*queKey)
 	if matchQue != nil {
-		elemIdx := matchQue.findMatchIdx(elemKey)
-		if elemIdx >= 0 {
-			elem := matchQue.matchElems[elemIdx]
-			elem.OnLeaveQueue(*queKey, elem, success)
-			xlog.InfoF("<queue_match> leave queue: queKey=%v, elem=%v",
-				queKey, *elem)
-		}
 		// 从该queKey的匹配队列中删除匹配元素
-		matchQue.matchElems = append(matchQue.matchElems[:elemIdx], matchQue.matchElems[elemIdx+1:]...)
+		if elem := matchQue.delMatch(elemKey); elem != nil {
+			elem.OnLeaveQueue(*queKey, elem, reason)
+			mqm.penaltyOnLeave(elem, reason)
+			mqm.getQueueStat(*queKey).counter.queueWait.observe(mqm.since(elem.StartTime).Seconds())
+			xlog.InfoF("<queue_match> leave queue: queKey=%v, reason=%v, elem=%v",
+				queKey, reason, *elem)
+		}
 	}
+	// 同时从其他队列中删除
+	mqm.leaveTicketQueues(elemKey)
 	// 删除查找索引
 	delete(mqm.elem2MatchQueue, elemKey)
 	return true
@@ -343,7 +516,7 @@ func (mqm *MatchQueueMgr) SetClientUse(clientKey ClientKey, noUse bool) {
 	}
 }
 
-// 设置基础配置
+// 设置基础配置, 为0(或空)的字段保持原值
 func (mqm *MatchQueueMgr) SetMatchBaseCfg(cfg MatchBaseCfg) {
 	if cfg.MatchTickGap > 0 {
 		mqm.baseCfg.MatchTickGap = cfg.MatchTickGap
@@ -351,6 +524,78 @@ func (mqm *MatchQueueMgr) SetMatchBaseCfg(cfg MatchBaseCfg) {
 	if cfg.ShowMatchTickGap > 0 {
 		mqm.baseCfg.ShowMatchTickGap = cfg.ShowMatchTickGap
 	}
+	// 0表示不修改, 避免只调其他字段时把已配置的超时清掉
+	if cfg.MaxWaitSecond != 0 {
+		mqm.baseCfg.MaxWaitSecond = cfg.MaxWaitSecond
+	}
+	if cfg.SnapshotPath != "" {
+		mqm.baseCfg.SnapshotPath = cfg.SnapshotPath
+	}
+	if cfg.SchedLenWeight > 0 {
+		mqm.baseCfg.SchedLenWeight = cfg.SchedLenWeight
+	}
+	if cfg.SchedWaitWeight > 0 {
+		mqm.baseCfg.SchedWaitWeight = cfg.SchedWaitWeight
+	}
+	if cfg.MaxBoostSecond != 0 {
+		mqm.baseCfg.MaxBoostSecond = cfg.MaxBoostSecond
+	}
+	if cfg.JobTimeoutMs != 0 {
+		mqm.baseCfg.JobTimeoutMs = cfg.JobTimeoutMs
+	}
+	if cfg.MaxMatchPerJob > 0 {
+		mqm.baseCfg.MaxMatchPerJob = cfg.MaxMatchPerJob
+	}
+}
+
+// 设置单个队列最长等待秒数, 优先于baseCfg. <=0表示该队列不限
+func (mqm *MatchQueueMgr) SetQueueMaxWait(queKey MatchQueueKey, second int64) {
+	if second < 0 {
+		second = 0
+	}
+	mqm.queMaxWait[queKey] = second
+}
+
+// 清除单个队列的最长等待设置, 改回使用baseCfg配置
+func (mqm *MatchQueueMgr) ClearQueueMaxWait(queKey MatchQueueKey) {
+	delete(mqm.queMaxWait, queKey)
+}
+
+// 获取队列最长等待秒数
+func (mqm *MatchQueueMgr) getQueueMaxWait(queKey MatchQueueKey) int64 {
+	if second, ok := mqm.queMaxWait[queKey]; ok {
+		return second
+	}
+	return mqm.baseCfg.MaxWaitSecond
+}
+
+// 把等待超时的elem移出队列
+func (mqm *MatchQueueMgr) checkWaitTimeout() {
+	type timeoutElem struct {
+		queKey MatchQueueKey
+		elem   *MatchElem
+	}
+	timeoutList := make([]timeoutElem, 0)
+	for queKey, oneQue := range mqm.waitingQueue {
+		maxWait := mqm.getQueueMaxWait(queKey)
+		if maxWait <= 0 {
+			continue
+		}
+		oneQue.matchElems.foreach(func(oneElem *MatchElem) bool {
+			// 多队列elem只按主队列配置判断
+			if mqm.elem2MatchQueue[oneElem.ElemKey] != queKey {
+				return true
+			}
+			if oneElem.WaitSecond() >= maxWait {
+				timeoutList = append(timeoutList, timeoutElem{queKey: queKey, elem: oneElem})
+			}
+			return true
+		})
+	}
+	for _, oneTimeout := range timeoutList {
+		xlog.InfoF("<queue_match> wait timeout: queKey=%v, elem=%v", oneTimeout.queKey, *oneTimeout.elem)
+		mqm.LeaveQueue(oneTimeout.elem.ElemKey, LeaveReasonTimeout)
+	}
 }
 
 func (mqm *MatchQueueMgr) findMatchAchieve(strategyType uint32) IMatchAchieve {
@@ -393,11 +638,13 @@ func (mqm *MatchQueueMgr) RegisterSupplyAchieve(strategyType uint32, achieve ISu
 
 func (mqm *MatchQueueMgr) Init(selfGetter xmodule.DModuleGetter) bool {
 	mqm.selfGetter = selfGetter
+	// 恢复上次关闭时的队列
+	mqm.LoadSnapshot()
 	return true
 }
 
 func (mqm *MatchQueueMgr) Run(delta int64) {
-	if mqm.tickTotal == 0 && mqm.getJobController() == nil {
+	if mqm.tickTotal == 0 && !mqm.syncJob && mqm.getJobController() == nil {
 		panic("MatchQueueMgr init fail, no job controller")
 	}
 	mqm.tickTotal++
@@ -406,44 +653,101 @@ func (mqm *MatchQueueMgr) Run(delta int64) {
 		// for print match information
 		buff := strings.Builder{}
 		for queKey, oneQue := range mqm.waitingQueue {
-			if len(oneQue.matchElems) > 0 || len(oneQue.supplyInfos) > 0 {
+			if oneQue.getElemLen() > 0 || len(oneQue.supplyInfos) > 0 {
 				baseStr := "\n\t\t\t<map=%d, strategy=%d> queueNum=%d, supplyNum=%d"
 				buff.WriteString(fmt.Sprintf(baseStr,
-					queKey.MapID, queKey.MatchStrategy, len(oneQue.matchElems), len(oneQue.supplyInfos)))
+					queKey.MapID, queKey.MatchStrategy, oneQue.getElemLen(), len(oneQue.supplyInfos)))
 			}
 		}
 		if buff.Len() > 0 {
 			xlog.InfoF("<queue_match> state: %s", buff.String())
 		}
 	}
+	// 每帧检查超时的job
+	mqm.checkJobDeadline()
 	// 检测是否需要调用匹配
 	if mqm.tickTotal%mqm.baseCfg.MatchTickGap != 0 {
 		return
 	}
-	// 调用一次匹配
+	// 先剔除超时的, 再调用一次匹配
+	mqm.checkWaitTimeout()
+	mqm.constraints.expireRecent(mqm.now())
 	tryMatchOnce(mqm)
+	mqm.runSyncJobs()
 }
 
 func (mqm *MatchQueueMgr) Destroy() {
+	// 先取消进行中的job, 把确认中的放回队列并保存快照, 再通知所有还在匹配中的elem.
+	// 保存进快照的重启后会恢复, 通知suspend; 没保存的(没开启/失败)通知shutdown
+	mqm.cancelJobs()
+	mqm.cancelReadyChecks()
+	saved := mqm.saveSnapshot()
+	leaveKeys := make([]MatchElemKey, 0, len(mqm.elem2MatchQueue))
+	for elemKey := range mqm.elem2MatchQueue {
+		leaveKeys = append(leaveKeys, elemKey)
+	}
+	for _, elemKey := range leaveKeys {
+		reason := LeaveReasonShutdown
+		if _, ok := saved[elemKey]; ok {
+			reason = LeaveReasonSuspend
+		}
+		mqm.LeaveQueue(elemKey, reason)
+	}
 }
 
 // --------------- 性能收集 ---------------
 
 type Metric struct {
-	totalMatchNum  int // 匹配中总人数
-	totalSupplyNum int // 增补中总人数
+	totalMatchNum  int                            // 匹配中总人数
+	totalSupplyNum int                            // 增补中总人数
+	queues         map[MatchQueueKey]*queueMetric // 每个队列的性能数据
 }
 
 func (m *Metric) Pull(mqm *MatchQueueMgr) {
-	m.totalMatchNum = 0
+	m.totalMatchNum = len(mqm.elem2MatchQueue)
 	m.totalSupplyNum = 0
-	for _, oneQue := range mqm.waitingQueue {
-		m.totalMatchNum += len(oneQue.matchElems)
+	m.queues = make(map[MatchQueueKey]*queueMetric, len(mqm.waitingQueue))
+	for queKey, oneQue := range mqm.waitingQueue {
 		m.totalSupplyNum += len(oneQue.supplyInfos)
+		m.queues[queKey] = &queueMetric{
+			matchLen:  oneQue.getElemLen(),
+			supplyLen: len(oneQue.supplyInfos),
+			counter:   newQueueCounter(),
+		}
+	}
+	for queKey, stat := range mqm.queStats {
+		oneMetric, ok := m.queues[queKey]
+		if !ok {
+			oneMetric = &queueMetric{}
+			m.queues[queKey] = oneMetric
+		}
+		oneMetric.counter = stat.counter.clone()
 	}
 }
 
 func (m *Metric) Push(gather *xmetric.Gather, ch chan<- prometheus.Metric) {
+	m.push(gather, ch)
+}
+
+func (m *Metric) push(gather metricPusher, ch chan<- prometheus.Metric) {
 	gather.PushGaugeMetric(ch, "match_totalmatch_len", float64(m.totalMatchNum), nil)
 	gather.PushGaugeMetric(ch, "match_totalsupply_len", float64(m.totalSupplyNum), nil)
+	labels := []string{"map", "strategy"}
+	for queKey, oneMetric := range m.queues {
+		mapID := strconv.FormatUint(uint64(queKey.MapID), 10)
+		strategy := strconv.FormatUint(uint64(queKey.MatchStrategy), 10)
+		counter := &oneMetric.counter
+		gather.PushGaugeMetric(ch, "match_queue_len", float64(oneMetric.matchLen), labels, mapID, strategy)
+		gather.PushGaugeMetric(ch, "match_queue_supply_len", float64(oneMetric.supplyLen), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_formed_total", float64(counter.matchNum), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_supply_total", float64(counter.supplyNum), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_ghost_reject_total", float64(counter.ghostReject), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_success_refuse_total", float64(counter.successRefuse), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_job_timeout_total", float64(counter.jobTimeout), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_job_late_total", float64(counter.jobLate), labels, mapID, strategy)
+		gather.PushCounterMetric(ch, "match_repair_total", float64(counter.matchRepair), labels, mapID, strategy)
+		counter.matchWait.push(gather, ch, "match_time_to_match_seconds", labels, mapID, strategy)
+		counter.queueWait.push(gather, ch, "match_time_in_queue_seconds", labels, mapID, strategy)
+		counter.jobCost.push(gather, ch, "match_job_duration_seconds", labels, mapID, strategy)
+	}
 }
@@ -0,0 +1,69 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxWaitEviction(t *testing.T) {
+	lonely := newTestElem(1, 1000)
+	trace := testTrace(MatchStrategyScore, 0, lonely)
+	setup := func(coll *MatchDataCollector) {
+		coll.GetMatchMgr().SetMatchBaseCfg(MatchBaseCfg{MaxWaitSecond: 5})
+		// 只调其他字段不能清掉已配置的超时
+		coll.GetMatchMgr().SetMatchBaseCfg(MatchBaseCfg{MatchTickGap: 5})
+	}
+	simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 8*time.Second, setup)
+	leaves := elemLeaves(lonely)
+	if len(leaves) == 0 || leaves[0] != LeaveReasonTimeout {
+		t.Fatalf("leaves=%v, want timeout first", leaves)
+	}
+}
+
+func TestMaxWaitResetUnlimited(t *testing.T) {
+	lonely := newTestElem(1, 1000)
+	trace := testTrace(MatchStrategyScore, 0, lonely)
+	setup := func(coll *MatchDataCollector) {
+		coll.GetMatchMgr().SetMatchBaseCfg(MatchBaseCfg{MaxWaitSecond: 5})
+		coll.GetMatchMgr().SetMatchBaseCfg(MatchBaseCfg{MaxWaitSecond: -1})
+	}
+	simMatch(t, MapInfo{MatchTotalNeed: 2}, trace, 8*time.Second, setup)
+	for _, reason := range elemLeaves(lonely) {
+		if reason == LeaveReasonTimeout {
+			t.Fatalf("leaves=%v, want no timeout", elemLeaves(lonely))
+		}
+	}
+}
+
+// 单个队列设置0不限, 清除后按baseCfg
+func TestQueueMaxWaitOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(mqm *MatchQueueMgr, queKey MatchQueueKey)
+		timeout bool
+	}{
+		{"queue longer", func(mqm *MatchQueueMgr, queKey MatchQueueKey) {
+			mqm.SetQueueMaxWait(queKey, 20)
+		}, false},
+		{"queue unlimited", func(mqm *MatchQueueMgr, queKey MatchQueueKey) {
+			mqm.SetQueueMaxWait(queKey, 0)
+		}, false},
+		{"queue cleared", func(mqm *MatchQueueMgr, queKey MatchQueueKey) {
+			mqm.SetQueueMaxWait(queKey, 0)
+			mqm.ClearQueueMaxWait(queKey)
+		}, true},
+	}
+	queKey := MatchQueueKey{MapID: testMapID, MatchStrategy: MatchStrategyScore}
+	for _, tt := range tests {
+		lonely := newTestElem(1, 1000)
+		setup := func(coll *MatchDataCollector) {
+			coll.GetMatchMgr().SetMatchBaseCfg(MatchBaseCfg{MaxWaitSecond: 5})
+			tt.setup(coll.GetMatchMgr(), queKey)
+		}
+		simMatch(t, MapInfo{MatchTotalNeed: 2}, testTrace(MatchStrategyScore, 0, lonely), 8*time.Second, setup)
+		leaves := elemLeaves(lonely)
+		if timeout := len(leaves) > 0 && leaves[0] == LeaveReasonTimeout; timeout != tt.timeout {
+			t.Fatalf("%s: leaves=%v, want timeout=%t", tt.name, leaves, tt.timeout)
+		}
+	}
+}
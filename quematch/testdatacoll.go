@@ -62,6 +62,8 @@ func (okdo *collMatchSuccess) SupplySuccess(result *MatchResult, supplyInfo *Sup
 type MatchDataCollector struct {
 	matchMgr    *MatchQueueMgr
 	dyModuleMgr xmodule.DModuleMgr
+	simClock    *VirtualClock // 模拟时的虚拟时钟, nil表示真实时间
+	simTickGap  time.Duration // 模拟时每帧推进的时间
 }
 
 func NewMatchDataCollector(do IMatchDataCollOK) *MatchDataCollector {
@@ -114,9 +116,12 @@ func (coll *MatchDataCollector) RegisterSupplyAchieve(strategyType uint32, achie
 func (coll *MatchDataCollector) TryMatch(matchNum int) {
 	for i := 0; i < matchNum; i++ {
 		for tickNum := int64(0); tickNum < coll.matchMgr.baseCfg.MatchTickGap; tickNum++ {
-			coll.dyModuleMgr.RunAll(1)
+			coll.runTick()
 		}
-		time.Sleep(time.Millisecond * 50)
-		coll.dyModuleMgr.RunAll(1)
+		// 模拟时job是同步执行的, 不用等
+		if coll.simClock == nil {
+			time.Sleep(time.Millisecond * 50)
+		}
+		coll.runTick()
 	}
 }
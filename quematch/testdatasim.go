@@ -0,0 +1,76 @@
+package quematch
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+	测试代码, 用虚拟时钟和同步job模拟匹配. 同样的到达序列得到同样的匹配结果, 用于回归对比
+*/
+
+// 一次进队
+type MatchArrival struct {
+	Offset time.Duration // 相对回放开始的时间
+	QueKey MatchQueueKey
+	Elem   *MatchElem
+}
+
+// 新建模拟用的collector: 虚拟时钟从start开始, 每帧推进tickGap, job同步执行.
+// 虚拟时钟只用于该collector的mgr, 模拟结束后调用EndSimulation
+func NewSimDataCollector(do IMatchDataCollOK, start time.Time, tickGap time.Duration) *MatchDataCollector {
+	coll := NewMatchDataCollector(do)
+	if coll == nil {
+		return nil
+	}
+	clock := NewVirtualClock(start)
+	coll.matchMgr.SetMatchClock(clock)
+	coll.matchMgr.SetSyncJob(true)
+	coll.simClock = clock
+	coll.simTickGap = tickGap
+	return coll
+}
+
+// 结束模拟
+func (coll *MatchDataCollector) EndSimulation() {
+	coll.dyModuleMgr.DestroyAll()
+}
+
+// 获取匹配mgr, 用于设置配置
+func (coll *MatchDataCollector) GetMatchMgr() *MatchQueueMgr {
+	return coll.matchMgr
+}
+
+// 当前时间, 模拟时为虚拟时间
+func (coll *MatchDataCollector) Now() time.Time {
+	return coll.matchMgr.now()
+}
+
+// 跑一帧, 模拟时推进虚拟时钟
+func (coll *MatchDataCollector) runTick() {
+	coll.dyModuleMgr.RunAll(1)
+	if coll.simClock != nil {
+		coll.simClock.Advance(coll.simTickGap)
+	}
+}
+
+// 按到达序列回放duration时长, 到达时间到了就进队. 只能在模拟时使用
+func (coll *MatchDataCollector) ReplayTrace(trace []MatchArrival, duration time.Duration) bool {
+	if coll.simClock == nil || coll.simTickGap <= 0 {
+		return false
+	}
+	arrivals := make([]MatchArrival, len(trace))
+	copy(arrivals, trace)
+	sort.SliceStable(arrivals, func(i, j int) bool {
+		return arrivals[i].Offset < arrivals[j].Offset
+	})
+	start := coll.simClock.Now()
+	nextIdx := 0
+	for elapsed := time.Duration(0); elapsed <= duration; elapsed = coll.simClock.Now().Sub(start) {
+		for ; nextIdx < len(arrivals) && arrivals[nextIdx].Offset <= elapsed; nextIdx++ {
+			coll.PushMatchElem(arrivals[nextIdx].QueKey, arrivals[nextIdx].Elem)
+		}
+		coll.runTick()
+	}
+	return true
+}
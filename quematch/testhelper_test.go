@@ -0,0 +1,140 @@
+package quematch
+
+import (
+	"testing"
+	"time"
+)
+
+// 测试用: 固定的到达序列跑虚拟时钟模拟, 收集所有匹配结果
+
+const testMapID = 1
+
+var testStart = time.Unix(1000000, 0)
+
+type testCollOK struct {
+	results []*MatchResult
+}
+
+func (c *testCollOK) CollMatchOK(result *MatchResult) {
+	c.results = append(c.results, result)
+}
+
+func (c *testCollOK) CollSupplyOK(*MatchResult, *SupplyInfo) {
+}
+
+// 记录离开原因
+type testElemFunc struct {
+	leaves []MatchLeaveReason
+}
+
+func (f *testElemFunc) OnEnterQueue(MatchQueueKey, *MatchElem) {
+}
+
+func (f *testElemFunc) OnLeaveQueue(_ MatchQueueKey, _ *MatchElem, reason MatchLeaveReason) {
+	f.leaves = append(f.leaves, reason)
+}
+
+// elem收到的离开原因
+func elemLeaves(elem *MatchElem) []MatchLeaveReason {
+	return elem.IElemFunc.(*testElemFunc).leaves
+}
+
+// 新建elem, 多个分数时为组队, 玩家ID为id*10+i
+func newTestElem(id uint64, scores ...int32) *MatchElem {
+	data := NewScoreMatchElemData()
+	for i, score := range scores {
+		data.Gamers = append(data.Gamers, ScoreMatchGamer{GamerID: id*10 + uint64(i), Score: score})
+	}
+	elemType := MatchElemPerson
+	if len(scores) > 1 {
+		elemType = MatchElemTeam
+	}
+	return NewMatchElem(MatchElemKey{ElemType: elemType, ElemID: id}, data, &testElemFunc{})
+}
+
+// 新建单人elem, 带可选职业
+func newTestRoleElem(id uint64, score int32, roles ...MatchRole) *MatchElem {
+	elem := newTestElem(id, score)
+	elem.ElemData.(*ScoreMatchElemData).Gamers[0].Roles = roles
+	return elem
+}
+
+// 全部在offset时刻进队
+func testTrace(strategy uint32, offset time.Duration, elems ...*MatchElem) []MatchArrival {
+	trace := make([]MatchArrival, 0, len(elems))
+	for _, oneElem := range elems {
+		trace = append(trace, MatchArrival{
+			Offset: offset,
+			QueKey: MatchQueueKey{MapID: testMapID, MatchStrategy: strategy},
+			Elem:   oneElem,
+		})
+	}
+	return trace
+}
+
+// 用虚拟时钟回放到达序列, 返回按成局顺序排列的匹配结果
+func simMatch(t *testing.T, mapInfo MapInfo, trace []MatchArrival, duration time.Duration,
+	setup func(coll *MatchDataCollector)) []*MatchResult {
+	t.Helper()
+	collOK := &testCollOK{}
+	coll := NewSimDataCollector(collOK, testStart, 100*time.Millisecond)
+	if coll == nil {
+		t.Fatal("NewSimDataCollector failed")
+	}
+	defer coll.EndSimulation()
+	if setup != nil {
+		setup(coll)
+	}
+	mapInfo.MapID = testMapID
+	coll.InitClientMapInfo(ClientKey{ServerID: 1}, mapInfo)
+	if !coll.ReplayTrace(trace, duration) {
+		t.Fatal("ReplayTrace failed")
+	}
+	return collOK.results
+}
+
+// 结果中的elemID, 按结果中的顺序
+func resultElemIDs(result *MatchResult) []uint64 {
+	ids := make([]uint64, 0, len(result.Groups))
+	result.ForeachMatchElem(func(oneElem *MatchElem, _ int) {
+		ids = append(ids, oneElem.ElemKey.ElemID)
+	})
+	return ids
+}
+
+// 结果中的elemID集合
+func resultElemSet(result *MatchResult) map[uint64]struct{} {
+	set := make(map[uint64]struct{}, len(result.Groups))
+	for _, id := range resultElemIDs(result) {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+// 检查结果正好是这些elem
+func checkResultElems(t *testing.T, result *MatchResult, ids ...uint64) {
+	t.Helper()
+	set := resultElemSet(result)
+	if len(set) != len(ids) || len(result.Groups) != len(ids) {
+		t.Fatalf("result elems=%v, want %v", resultElemIDs(result), ids)
+	}
+	for _, id := range ids {
+		if _, ok := set[id]; !ok {
+			t.Fatalf("result elems=%v, want %v", resultElemIDs(result), ids)
+		}
+	}
+}
+
+// 手动生成一个已投递的匹配job, 每组elem为一个匹配结果. 用于直接测试DoReturn
+func newTestMatchJob(mqm *MatchQueueMgr, queKey MatchQueueKey, groups ...[]*MatchElem) *MatchJobBase {
+	matchJob := newMatchJob(newMatchAchieve(queKey.MatchStrategy, mqm))
+	matchJob.matchMgrGetter = mqm.selfGetter
+	matchJob.cliKey = ClientKey{ServerID: 1}
+	matchJob.QueKey = queKey
+	matchJob.QueMap = mqm.mapsInfo[queKey.MapID]
+	matchJob.Ctx, matchJob.seq = mqm.startQueueJob(mqm.getOrNewQueue(queKey), nil)
+	for i, oneGroup := range groups {
+		matchJob.ResultAt(i).AddGroup(oneGroup...)
+	}
+	return matchJob
+}
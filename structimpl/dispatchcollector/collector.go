@@ -86,6 +86,7 @@ func (c *oneCollect) collectOne(key uint32, agree bool) {
 // 返回: 收集是否结束
 func (c *oneCollect) checkCollectOver() bool {
 	collFinish := ECollectRetUnknown
+	hasUnknown := false
 	var refuseRoleID uint32
 	c.ForeachColl(func(roleID uint32, state int, exData interface{}) bool {
 		switch state {
@@ -99,15 +100,18 @@ func (c *oneCollect) checkCollectOver() bool {
 			collFinish = ECollectRetSuccess
 			return true
 		case ECollectUnknown:
-			// 未知
-			collFinish = ECollectRetUnknown
-			return false
+			// 未知, 继续看有没有人拒绝
+			hasUnknown = true
+			return true
 		default:
 			xlog.Errorf("DispatchCollectMgr CollectOne err, unKnown state=%d of roleID=%d",
 				state, roleID)
 		}
 		return true
 	})
+	if collFinish != ECollectRetFailed && hasUnknown {
+		collFinish = ECollectRetUnknown
+	}
 	// 根据收集结果执行回调给业务层
 	switch collFinish {
 	case ECollectRetSuccess:
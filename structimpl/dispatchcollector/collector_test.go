@@ -0,0 +1,59 @@
+package dispatchcollector
+
+import (
+	"testing"
+)
+
+// 记录收集结束的回调
+type testDispatch struct {
+	success int
+	failed  []uint32
+}
+
+func (d *testDispatch) OnCollectOne(uint32, uint32, bool) {
+}
+
+func (d *testDispatch) OnCollectSuccess(uint32) {
+	d.success++
+}
+
+func (d *testDispatch) OnCollectFailed(_ uint32, roleID uint32) {
+	d.failed = append(d.failed, roleID)
+}
+
+func TestCheckCollectOver(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  []int // 下标+1为key
+		over    bool
+		success bool
+		refuse  uint32 // 失败时回调的key, 0表示不失败
+	}{
+		{"all unknown", []int{ECollectUnknown, ECollectUnknown}, false, false, 0},
+		{"accept and unknown", []int{ECollectAccept, ECollectUnknown}, false, false, 0},
+		{"all accept", []int{ECollectAccept, ECollectAccept}, true, true, 0},
+		{"accept and refuse", []int{ECollectAccept, ECollectRefuse}, true, false, 2},
+		{"refuse and unknown", []int{ECollectUnknown, ECollectRefuse}, true, false, 2},
+	}
+	for _, tt := range tests {
+		d := &testDispatch{}
+		coll := newOneCollect(1, nil, d)
+		for i, state := range tt.states {
+			key := uint32(i + 1)
+			coll.AddOneCollect(key, nil)
+			coll.collInfoM[key].result = state
+		}
+		if over := coll.checkCollectOver(); over != tt.over {
+			t.Fatalf("%s: over=%t, want %t", tt.name, over, tt.over)
+		}
+		if (d.success == 1) != tt.success || d.success > 1 {
+			t.Fatalf("%s: success calls=%d, want success=%t", tt.name, d.success, tt.success)
+		}
+		if tt.refuse == 0 && len(d.failed) != 0 {
+			t.Fatalf("%s: unexpected failed=%v", tt.name, d.failed)
+		}
+		if tt.refuse != 0 && (len(d.failed) != 1 || d.failed[0] != tt.refuse) {
+			t.Fatalf("%s: failed=%v, want [%d]", tt.name, d.failed, tt.refuse)
+		}
+	}
+}